@@ -0,0 +1,287 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+)
+
+const (
+	statusAdded   = "added"
+	statusRemoved = "removed"
+	statusChanged = "changed"
+)
+
+// dirTreeDiff renders the merged tree of oldPath and newPath with every
+// entry marked as added, removed or changed. It reports whether the trees
+// differ at all.
+func dirTreeDiff(out io.Writer, oldPath string, newPath string, opts *options) (bool, error) {
+	r, err := newRenderer(out, opts)
+
+	if err != nil {
+		return false, fmt.Errorf("error renderer: %v", err)
+	}
+
+	oldSrc, err := openTree(oldPath)
+
+	if err != nil {
+		return false, fmt.Errorf("error open: %v", err)
+	}
+
+	defer oldSrc.Close()
+
+	newSrc, err := openTree(newPath)
+
+	if err != nil {
+		return false, fmt.Errorf("error open: %v", err)
+	}
+
+	defer newSrc.Close()
+
+	_, oldDir, err := loadTree(oldSrc, oldPath, opts, true)
+
+	if err != nil {
+		return false, err
+	}
+
+	root, newDir, err := loadTree(newSrc, newPath, opts, true)
+
+	if err != nil {
+		return false, err
+	}
+
+	d := &treeDiff{oldFS: oldSrc, newFS: newSrc, opts: opts}
+	merged, changed, err := d.merge(oldDir, newDir)
+
+	if err != nil {
+		return false, fmt.Errorf("error diff: %v", err)
+	}
+
+	if changed {
+		root.status = statusChanged
+	}
+
+	err = r.begin(root)
+
+	if err != nil {
+		return false, fmt.Errorf("error render: %v", err)
+	}
+
+	err = walk(r, merged, opts)
+
+	if err != nil {
+		return false, fmt.Errorf("error walk: %v", err)
+	}
+
+	return changed, r.end()
+}
+
+type treeDiff struct {
+	oldFS fs.FS
+	newFS fs.FS
+	opts  *options
+}
+
+// merge builds one listing out of the matching directories of both trees.
+// Either side may be nil for a directory that exists only in the other one.
+func (d *treeDiff) merge(oldDir *dirListing, newDir *dirListing) (*dirListing, bool, error) {
+	oldEntries := map[string]int{}
+	newEntries := map[string]int{}
+	names := []string{}
+
+	if oldDir != nil {
+		for i, e := range oldDir.entries {
+			oldEntries[e.name] = i
+			names = append(names, e.name)
+		}
+	}
+
+	if newDir != nil {
+		for i, e := range newDir.entries {
+			newEntries[e.name] = i
+			if _, ok := oldEntries[e.name]; !ok {
+				names = append(names, e.name)
+			}
+		}
+	}
+
+	sort.Strings(names)
+
+	merged := &dirListing{}
+	if newDir != nil {
+		merged.level = newDir.level
+	} else if oldDir != nil {
+		merged.level = oldDir.level
+	}
+	changed := false
+
+	add := func(e *entry, child *dirListing, status string) {
+		if status == "" && d.opts.changedOnly {
+			return
+		}
+		merged.entries = append(merged.entries, e)
+		merged.children = append(merged.children, child)
+		merged.filters = append(merged.filters, nil)
+		if status != "" {
+			changed = true
+		}
+	}
+
+	for _, name := range names {
+		i, inOld := oldEntries[name]
+		j, inNew := newEntries[name]
+
+		var oldEntry, newEntry *entry
+		var oldChild, newChild *dirListing
+		if inOld {
+			oldEntry, oldChild = oldDir.entries[i], oldDir.children[i]
+		}
+		if inNew {
+			newEntry, newChild = newDir.entries[j], newDir.children[j]
+		}
+
+		if inOld && inNew && oldEntry.isDir == newEntry.isDir {
+			e, child, err := d.compare(oldEntry, newEntry, oldChild, newChild)
+			if err != nil {
+				return nil, false, err
+			}
+			add(e, child, e.status)
+			continue
+		}
+
+		if inOld {
+			e, child := markTree(oldEntry, oldChild, statusRemoved)
+			add(e, child, statusRemoved)
+		}
+
+		if inNew {
+			e, child := markTree(newEntry, newChild, statusAdded)
+			add(e, child, statusAdded)
+		}
+	}
+
+	merged.sortEntries(d.opts.sortBy)
+
+	return merged, changed, nil
+}
+
+func (d *treeDiff) compare(oldEntry *entry, newEntry *entry, oldChild *dirListing, newChild *dirListing) (*entry, *dirListing, error) {
+	e := *newEntry
+
+	if e.isDir {
+		if oldChild == nil && newChild == nil {
+			if !sameSummary(oldEntry.summary, newEntry.summary) {
+				e.status = statusChanged
+			}
+			return &e, nil, nil
+		}
+
+		child, changed, err := d.merge(oldChild, newChild)
+		if err != nil {
+			return nil, nil, err
+		}
+		if changed {
+			e.status = statusChanged
+		}
+		return &e, child, nil
+	}
+
+	if oldEntry.size != newEntry.size {
+		e.changes = append(e.changes, "size")
+	}
+
+	if oldEntry.mode != newEntry.mode {
+		e.changes = append(e.changes, "mode")
+	}
+
+	if oldEntry.linkTarget != newEntry.linkTarget {
+		e.changes = append(e.changes, "target")
+	}
+
+	if d.opts.hash {
+		if oldEntry.size == newEntry.size && (!e.isLink || e.followed) {
+			same, err := d.sameContent(e.path)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !same {
+				e.changes = append(e.changes, "hash")
+			}
+		}
+	} else if !oldEntry.modTime.Equal(newEntry.modTime) {
+		e.changes = append(e.changes, "mtime")
+	}
+
+	if len(e.changes) > 0 {
+		e.status = statusChanged
+	}
+
+	return &e, nil, nil
+}
+
+func (d *treeDiff) sameContent(rel string) (bool, error) {
+	oldHash, err := hashFile(d.oldFS, rel)
+	if err != nil {
+		return false, err
+	}
+
+	newHash, err := hashFile(d.newFS, rel)
+	if err != nil {
+		return false, err
+	}
+
+	return oldHash == newHash, nil
+}
+
+func hashFile(fsys fs.FS, name string) ([sha256.Size]byte, error) {
+	sum := [sha256.Size]byte{}
+	f, err := fsys.Open(name)
+
+	if err != nil {
+		return sum, err
+	}
+
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return sum, err
+	}
+
+	copy(sum[:], h.Sum(nil))
+
+	return sum, nil
+}
+
+// markTree copies e and everything loaded below it with the given status.
+func markTree(e *entry, dir *dirListing, status string) (*entry, *dirListing) {
+	marked := *e
+	marked.status = status
+
+	if dir == nil {
+		return &marked, nil
+	}
+
+	copied := &dirListing{
+		level:    dir.level,
+		entries:  make([]*entry, len(dir.entries)),
+		filters:  make([]*entryFilter, len(dir.entries)),
+		children: make([]*dirListing, len(dir.entries)),
+	}
+
+	for i := range dir.entries {
+		copied.entries[i], copied.children[i] = markTree(dir.entries[i], dir.children[i], status)
+	}
+
+	return &marked, copied
+}
+
+func sameSummary(a *treeSummary, b *treeSummary) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.dirs == b.dirs && a.files == b.files && a.size == b.size
+}
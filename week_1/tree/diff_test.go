@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func makeReleases(t *testing.T) (string, string) {
+	oldRoot := makeTree(t, map[string]string{
+		"bin/app":         "v1",
+		"conf/app.yaml":   "port: 80",
+		"docs/guide.md":   "guide",
+		"lib/core.so":     "core",
+		"lib/legacy.so":   "old",
+		"notes":           "file",
+		"static/logo.png": "png",
+	})
+	newRoot := makeTree(t, map[string]string{
+		"bin/app":         "v2",
+		"conf/app.yaml":   "port: 8080",
+		"lib/core.so":     "core",
+		"lib/plugin.so":   "plugin",
+		"notes/todo.txt":  "todo",
+		"static/logo.png": "png",
+	})
+	return oldRoot, newRoot
+}
+
+func runDiff(t *testing.T, oldRoot string, newRoot string, args ...string) (string, bool) {
+	t.Helper()
+	oldPath, newPath, opts, err := parseDiffArgs(append([]string{oldRoot, newRoot}, args...))
+	if err != nil {
+		t.Fatalf("parse args: %v", err)
+	}
+	out := new(bytes.Buffer)
+	changed, err := dirTreeDiff(out, oldPath, newPath, opts)
+	if err != nil {
+		t.Fatalf("dirTreeDiff: %v", err)
+	}
+	return out.String(), changed
+}
+
+func TestTreeDiff(t *testing.T) {
+	oldRoot, newRoot := makeReleases(t)
+
+	expected := `├───bin
+│	└───app (2b)
+├───[~] conf
+│	└───[~] app.yaml (10b) [size]
+├───[-] docs
+│	└───[-] guide.md (5b)
+├───[~] lib
+│	├───core.so (4b)
+│	├───[-] legacy.so (3b)
+│	└───[+] plugin.so (6b)
+├───[-] notes (4b)
+├───[+] notes
+│	└───[+] todo.txt (4b)
+└───static
+	└───logo.png (3b)
+`
+	result, changed := runDiff(t, oldRoot, newRoot, "-f")
+	if !changed {
+		t.Errorf("expected trees to differ")
+	}
+	if result != expected {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+
+	expected = `├───[~] bin
+│	└───[~] app (2b) [hash]
+├───[~] conf
+│	└───[~] app.yaml (10b) [size]
+├───[-] docs
+│	└───[-] guide.md (5b)
+├───[~] lib
+│	├───[-] legacy.so (3b)
+│	└───[+] plugin.so (6b)
+├───[-] notes (4b)
+└───[+] notes
+	└───[+] todo.txt (4b)
+`
+	result, _ = runDiff(t, oldRoot, newRoot, "-f", "--hash", "--changed-only", "-j", "4")
+	if result != expected {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+
+	// directories cut off by -L are compared by their summaries
+	expected = `├───[~] conf/ (0 dirs, 1 file, 10b)
+├───[-] docs/ (0 dirs, 1 file, 5b)
+├───[~] lib/ (0 dirs, 2 files, 10b)
+├───[-] notes (4b)
+└───[+] notes/ (0 dirs, 1 file, 4b)
+`
+	result, _ = runDiff(t, oldRoot, newRoot, "-f", "--changed-only", "-L", "1")
+	if result != expected {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+}
+
+func TestTreeDiffSameTree(t *testing.T) {
+	root := makeTree(t, map[string]string{"a/b.txt": "b"})
+	result, changed := runDiff(t, root, root, "-f", "--hash", "--changed-only")
+	if changed || result != "" {
+		t.Errorf("expected no changes, got %v:\n%s", changed, result)
+	}
+}
+
+func TestTreeDiffJSON(t *testing.T) {
+	oldRoot, newRoot := makeReleases(t)
+	result, _ := runDiff(t, oldRoot, newRoot, "-f", "--changed-only", "--format", "ndjson")
+
+	statuses := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(result), "\n") {
+		var record struct {
+			Path    string   `json:"path"`
+			Type    string   `json:"type"`
+			Status  string   `json:"status"`
+			Changes []string `json:"changes"`
+		}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid json line %q: %v", line, err)
+		}
+		statuses[record.Path+" "+record.Type] = record.Status + " " + strings.Join(record.Changes, ",")
+	}
+
+	expected := map[string]string{
+		"conf directory":      "changed ",
+		"conf/app.yaml file":  "changed size",
+		"docs directory":      "removed ",
+		"docs/guide.md file":  "removed ",
+		"lib directory":       "changed ",
+		"lib/legacy.so file":  "removed ",
+		"lib/plugin.so file":  "added ",
+		"notes file":          "removed ",
+		"notes directory":     "added ",
+		"notes/todo.txt file": "added ",
+	}
+	if len(statuses) != len(expected) {
+		t.Errorf("unexpected records: %v", statuses)
+	}
+	for key, status := range expected {
+		if statuses[key] != status {
+			t.Errorf("%s: got %q, expected %q", key, statuses[key], status)
+		}
+	}
+}
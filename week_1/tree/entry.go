@@ -0,0 +1,194 @@
+package main
+
+import (
+	"archive/tar"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+type entry struct {
+	name       string
+	path       string
+	isDir      bool
+	size       int64
+	mode       fs.FileMode
+	modTime    time.Time
+	info       fs.FileInfo
+	id         fileID
+	hasID      bool
+	isLink     bool
+	linkTarget string
+	followed   bool
+	recursive  bool
+	summary    *treeSummary
+	stats      *contentStats
+	status     string
+	changes    []string
+}
+
+// readDirEntries returns every entry of dir sorted by name. Symlinks are
+// resolved when follow is set, regular files are skipped with dirsOnly so
+// that their info is not read when nobody needs it.
+func readDirEntries(fsys fs.FS, dir string, follow bool, dirsOnly bool) ([]*entry, error) {
+	files, err := fs.ReadDir(fsys, dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*entry, 0, len(files))
+
+	for _, file := range files {
+		if !isValidName(file.Name()) {
+			// a broken fs.FS must not send the walk back to the root
+			continue
+		}
+		if dirsOnly && !file.IsDir() && file.Type()&fs.ModeSymlink == 0 {
+			continue
+		}
+		e, err := newEntry(fsys, path.Join(dir, file.Name()), file, follow)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+func isValidName(name string) bool {
+	return name != "." && !strings.Contains(name, "/") && fs.ValidPath(name)
+}
+
+func newEntry(fsys fs.FS, name string, file fs.DirEntry, follow bool) (*entry, error) {
+	info, err := file.Info()
+
+	if err != nil {
+		return nil, err
+	}
+
+	e := entryFromInfo(info, name)
+
+	if info.Mode()&fs.ModeSymlink == 0 {
+		return e, nil
+	}
+
+	e.isLink = true
+	e.linkTarget, err = readLink(fsys, name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !follow {
+		return e, nil
+	}
+
+	target, err := fs.Stat(fsys, name)
+
+	if err != nil {
+		// broken link, shown as is
+		return e, nil
+	}
+
+	id, hasID := fileIdentity(target)
+	if target.IsDir() && !hasID {
+		// without inode numbers loops cannot be detected
+		return e, nil
+	}
+
+	e.followed = true
+	e.isDir = target.IsDir()
+	e.size = target.Size()
+	e.id, e.hasID = id, hasID
+
+	return e, nil
+}
+
+// newRootEntry describes the root of fsys, name is what it is shown as.
+func newRootEntry(fsys fs.FS, name string) (*entry, error) {
+	info, err := fs.Stat(fsys, ".")
+
+	if err != nil {
+		return nil, err
+	}
+
+	e := entryFromInfo(info, "")
+	e.name = name
+
+	return e, nil
+}
+
+func entryFromInfo(info fs.FileInfo, rel string) *entry {
+	id, hasID := fileIdentity(info)
+	return &entry{
+		name:    info.Name(),
+		path:    rel,
+		isDir:   info.IsDir(),
+		size:    info.Size(),
+		mode:    info.Mode(),
+		modTime: info.ModTime(),
+		info:    info,
+		id:      id,
+		hasID:   hasID,
+	}
+}
+
+func (e *entry) kind() string {
+	if e.isDir {
+		return "directory"
+	}
+	if e.isLink && !e.followed {
+		return "link"
+	}
+	return "file"
+}
+
+func (e *entry) owner() string {
+	if hdr, ok := e.info.Sys().(*tar.Header); ok {
+		return hdr.Uname
+	}
+	owner, _ := fileOwner(e.info)
+	return owner
+}
+
+func (e *entry) group() string {
+	if hdr, ok := e.info.Sys().(*tar.Header); ok {
+		return hdr.Gname
+	}
+	_, group := fileOwner(e.info)
+	return group
+}
+
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// dirChain is the list of directories from the root down to the current
+// one, used to stop following links that point back to an ancestor.
+type dirChain struct {
+	id     fileID
+	parent *dirChain
+}
+
+func (c *dirChain) push(e *entry) *dirChain {
+	if !e.hasID {
+		return c
+	}
+	return &dirChain{id: e.id, parent: c}
+}
+
+func (c *dirChain) contains(e *entry) bool {
+	if !e.hasID {
+		return false
+	}
+	for ; c != nil; c = c.parent {
+		if c.id == e.id {
+			return true
+		}
+	}
+	return false
+}
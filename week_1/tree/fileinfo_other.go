@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import "io/fs"
+
+func fileIdentity(info fs.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
+
+func fileOwner(info fs.FileInfo) (string, string) {
+	return "", ""
+}
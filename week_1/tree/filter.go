@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+)
+
+type entryFilter struct {
+	include   []string
+	exclude   []string
+	gitignore bool
+	rules     []ignoreRule
+	dir       string
+}
+
+func newEntryFilter(opts *options) *entryFilter {
+	return &entryFilter{
+		include:   opts.include,
+		exclude:   opts.exclude,
+		gitignore: opts.gitignore,
+	}
+}
+
+// enter returns the filter for the directory name inside the current one,
+// picking up its .gitignore when gitignore support is on.
+func (f *entryFilter) enter(fsys fs.FS, name string) (*entryFilter, error) {
+	sub := *f
+	sub.dir = joinRel(f.dir, name)
+
+	if !f.gitignore {
+		return &sub, nil
+	}
+
+	rules, err := readGitignore(fsys, fsPath(sub.dir), sub.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rules) > 0 {
+		sub.rules = append(append([]ignoreRule{}, f.rules...), rules...)
+	}
+
+	return &sub, nil
+}
+
+func (f *entryFilter) apply(entries []*entry, printFiles bool) []*entry {
+	acc := []*entry{}
+	for _, e := range entries {
+		if f.allow(e.name, e.isDir, printFiles) {
+			acc = append(acc, e)
+		}
+	}
+	return acc
+}
+
+// allow reports whether the entry is shown. Excludes and .gitignore rules
+// hide both files and directories; includes only narrow down the entries
+// being listed, i.e. files with -f and directories without it.
+func (f *entryFilter) allow(name string, isDir bool, printFiles bool) bool {
+	rel := joinRel(f.dir, name)
+
+	if f.gitignore && isDir && name == ".git" {
+		return false
+	}
+
+	if matchAny(f.exclude, name, rel) {
+		return false
+	}
+
+	if f.gitignore && isIgnored(f.rules, rel, isDir) {
+		return false
+	}
+
+	if len(f.include) > 0 && isDir != printFiles {
+		return matchAny(f.include, name, rel)
+	}
+
+	return true
+}
+
+// matchAny matches patterns with a slash against the path relative to the
+// root and all other patterns against the base name.
+func matchAny(patterns []string, name string, rel string) bool {
+	for _, pattern := range patterns {
+		target := name
+		if strings.Contains(pattern, "/") {
+			target = rel
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// fsPath turns a path relative to the root into an fs.FS name.
+func fsPath(rel string) string {
+	if rel == "" {
+		return "."
+	}
+	return rel
+}
+
+func joinRel(dir string, name string) string {
+	if dir == "" {
+		return name
+	}
+	if name == "" {
+		return dir
+	}
+	return dir + "/" + name
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testModTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func makeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		full := filepath.Join(root, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(full, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(0o644)
+		if info.IsDir() {
+			mode = 0o755
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+		return os.Chtimes(path, testModTime, testModTime)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func runTree(t *testing.T, root string, args ...string) string {
+	t.Helper()
+	_, opts, err := parseArgs(append([]string{root}, args...))
+	if err != nil {
+		t.Fatalf("parse args: %v", err)
+	}
+	out := new(bytes.Buffer)
+	if err := dirTreeWithOptions(out, root, opts); err != nil {
+		t.Fatalf("dirTree: %v", err)
+	}
+	return out.String()
+}
+
+func TestTreeIncludeExclude(t *testing.T) {
+	root := makeTree(t, map[string]string{
+		"main.go":                 "package main",
+		"readme.md":               "doc",
+		"vendor/lib/lib.go":       "package lib",
+		"node_modules/x/index.js": "x",
+		"pkg/util.go":             "package pkg",
+		"pkg/util_test.go":        "package pkg",
+	})
+
+	expected := `├───main.go (12b)
+└───pkg
+	└───util.go (11b)
+`
+	result := runTree(t, root, "-f", "--include", "*.go", "--exclude", "vendor", "--exclude=node_modules", "--exclude", "pkg/*_test.go")
+	if result != expected {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+
+	expected = `└───pkg
+`
+	result = runTree(t, root, "--include", "p*")
+	if result != expected {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+}
+
+func TestTreeGitignore(t *testing.T) {
+	root := makeTree(t, map[string]string{
+		".gitignore":         "# build output\n/bin/\n*.log\n!keep.log\nnode_modules/\n",
+		".git/HEAD":          "ref",
+		"bin/app":            "elf",
+		"cmd/bin/tool.go":    "package bin",
+		"cmd/.gitignore":     "*.tmp\n!important.tmp\n",
+		"cmd/a.tmp":          "a",
+		"cmd/important.tmp":  "b",
+		"debug.log":          "log",
+		"keep.log":           "log",
+		"web/node_modules/m": "m",
+		"web/app.js":         "js",
+		"web/sub/trace.log":  "log",
+		"web/sub/cache.tmp":  "tmp",
+	})
+
+	expected := `├───.gitignore (51b)
+├───cmd
+│	├───.gitignore (21b)
+│	├───bin
+│	│	└───tool.go (11b)
+│	└───important.tmp (1b)
+├───keep.log (3b)
+└───web
+	├───app.js (2b)
+	└───sub
+		└───cache.tmp (3b)
+`
+	result := runTree(t, root, "-f", "--gitignore")
+	if result != expected {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+}
+
+func TestGitignorePatterns(t *testing.T) {
+	rules, err := parseGitignore(strings.NewReader("docs/**/*.pdf\n**/tmp\nbuild/**\n\\#hash\nfoo[0-9]\n"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"docs/a.pdf", false, true},
+		{"docs/x/y/a.pdf", false, true},
+		{"other/docs/a.pdf", false, false},
+		{"a/b/tmp", true, true},
+		{"tmp", false, true},
+		{"build/out.o", false, true},
+		{"build", true, false},
+		{"#hash", false, true},
+		{"foo7", false, true},
+		{"fooa", false, false},
+	}
+
+	for _, c := range cases {
+		if got := isIgnored(rules, c.path, c.isDir); got != c.ignored {
+			t.Errorf("isIgnored(%q) = %v, expected %v", c.path, got, c.ignored)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+type entryRecord struct {
+	Path      string         `json:"path,omitempty"`
+	Name      string         `json:"name"`
+	Type      string         `json:"type"`
+	Size      int64          `json:"size"`
+	Mode      string         `json:"mode"`
+	Mtime     string         `json:"mtime"`
+	Target    string         `json:"target,omitempty"`
+	Owner     string         `json:"owner,omitempty"`
+	Group     string         `json:"group,omitempty"`
+	Recursive bool           `json:"recursive,omitempty"`
+	Status    string         `json:"status,omitempty"`
+	Changes   []string       `json:"changes,omitempty"`
+	Summary   *summaryRecord `json:"summary,omitempty"`
+	Lines     int            `json:"lines,omitempty"`
+	Language  string         `json:"language,omitempty"`
+	Stats     *statsRecord   `json:"stats,omitempty"`
+}
+
+type summaryRecord struct {
+	Dirs  int   `json:"dirs"`
+	Files int   `json:"files"`
+	Size  int64 `json:"size"`
+}
+
+type statsRecord struct {
+	Files     int                       `json:"files"`
+	Lines     int                       `json:"lines"`
+	Languages map[string]languageRecord `json:"languages"`
+}
+
+type languageRecord struct {
+	Files int `json:"files"`
+	Lines int `json:"lines"`
+}
+
+func newStatsRecord(s *contentStats) *statsRecord {
+	record := &statsRecord{Files: s.files, Lines: s.lines, Languages: map[string]languageRecord{}}
+	for name, l := range s.languages {
+		record.Languages[name] = languageRecord{Files: l.files, Lines: l.lines}
+	}
+	return record
+}
+
+func newEntryRecord(e *entry, withPath bool, opts *options) entryRecord {
+	record := entryRecord{
+		Name:      e.name,
+		Type:      e.kind(),
+		Size:      e.size,
+		Mode:      e.mode.String(),
+		Mtime:     e.modTime.Format(time.RFC3339),
+		Target:    e.linkTarget,
+		Recursive: e.recursive,
+		Status:    e.status,
+		Changes:   e.changes,
+	}
+	if withPath {
+		record.Path = e.path
+	}
+	if opts.showOwner {
+		record.Owner = e.owner()
+	}
+	if opts.showGroup {
+		record.Group = e.group()
+	}
+	if e.summary != nil {
+		record.Summary = &summaryRecord{Dirs: e.summary.dirs, Files: e.summary.files, Size: e.summary.size}
+	}
+	if e.stats != nil && e.isDir {
+		record.Stats = newStatsRecord(e.stats)
+	} else if e.stats != nil {
+		record.Lines = e.stats.lines
+		record.Language = e.stats.language()
+	}
+	return record
+}
+
+// jsonRenderer streams one nested document, so directories are written as
+// soon as they are entered and never kept in memory with their children.
+type jsonRenderer struct {
+	out   io.Writer
+	opts  *options
+	first []bool
+}
+
+func (j *jsonRenderer) begin(root *entry) error {
+	j.first = []bool{true}
+	return j.open(root)
+}
+
+func (j *jsonRenderer) file(e *entry, isLast bool) error {
+	data, err := j.record(e)
+	if err != nil {
+		return err
+	}
+	_, err = j.out.Write(data)
+	return err
+}
+
+func (j *jsonRenderer) openDir(e *entry, isLast bool) error {
+	return j.open(e)
+}
+
+func (j *jsonRenderer) closeDir(e *entry) error {
+	j.first = j.first[:len(j.first)-1]
+	_, err := io.WriteString(j.out, "]}")
+	return err
+}
+
+func (j *jsonRenderer) end() error {
+	_, err := io.WriteString(j.out, "]}\n")
+	return err
+}
+
+func (j *jsonRenderer) open(e *entry) error {
+	data, err := j.record(e)
+	if err != nil {
+		return err
+	}
+	data = append(bytes.TrimSuffix(data, []byte("}")), `,"children":[`...)
+	j.first = append(j.first, true)
+	_, err = j.out.Write(data)
+	return err
+}
+
+func (j *jsonRenderer) record(e *entry) ([]byte, error) {
+	data, err := json.Marshal(newEntryRecord(e, false, j.opts))
+	if err != nil {
+		return nil, err
+	}
+
+	last := len(j.first) - 1
+	if !j.first[last] {
+		data = append([]byte(","), data...)
+	}
+	j.first[last] = false
+
+	return data, nil
+}
+
+// ndjsonRenderer writes every entry as a separate JSON line with its path
+// relative to the root, the root itself is not written.
+type ndjsonRenderer struct {
+	out  io.Writer
+	opts *options
+}
+
+func (n *ndjsonRenderer) begin(root *entry) error {
+	return nil
+}
+
+func (n *ndjsonRenderer) file(e *entry, isLast bool) error {
+	return n.write(e)
+}
+
+func (n *ndjsonRenderer) openDir(e *entry, isLast bool) error {
+	return n.write(e)
+}
+
+func (n *ndjsonRenderer) closeDir(e *entry) error {
+	return nil
+}
+
+func (n *ndjsonRenderer) end() error {
+	return nil
+}
+
+func (n *ndjsonRenderer) write(e *entry) error {
+	data, err := json.Marshal(newEntryRecord(e, true, n.opts))
+	if err != nil {
+		return err
+	}
+	_, err = n.out.Write(append(data, '\n'))
+	return err
+}
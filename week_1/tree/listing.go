@@ -0,0 +1,255 @@
+package main
+
+import (
+	"io/fs"
+	"path"
+	"sort"
+	"sync"
+)
+
+// dirListing is one read directory: its sorted and filtered entries plus
+// what is needed to descend into each subdirectory. children are filled
+// ahead of time by prefetch, otherwise they are loaded while walking.
+type dirListing struct {
+	fsys       fs.FS
+	path       string
+	level      int
+	entries    []*entry
+	filters    []*entryFilter
+	children   []*dirListing
+	filesSize  int64
+	filesStats *contentStats
+	filter     *entryFilter
+	chain      *dirChain
+}
+
+func loadDir(fsys fs.FS, name string, opts *options, filter *entryFilter, level int, chain *dirChain) (*dirListing, error) {
+	entries, files, err := readEntries(fsys, name, opts, filter)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var filesStats *contentStats
+
+	if opts.stats {
+		filesStats, err = countFiles(fsys, files)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dir := &dirListing{
+		fsys:       fsys,
+		path:       name,
+		level:      level,
+		entries:    entries,
+		filters:    make([]*entryFilter, len(entries)),
+		children:   make([]*dirListing, len(entries)),
+		filesSize:  getFilesSize(files),
+		filesStats: filesStats,
+		filter:     filter,
+		chain:      chain,
+	}
+
+	for i, e := range entries {
+		if !e.isDir {
+			continue
+		}
+
+		if chain.contains(e) {
+			e.recursive = true
+			continue
+		}
+
+		subFilter, err := filter.enter(fsys, e.name)
+		if err != nil {
+			return nil, err
+		}
+		dir.filters[i] = subFilter
+
+		if !dir.childrenPruned(opts) {
+			continue
+		}
+
+		summary, err := summarize(fsys, dir.childPath(i), opts, subFilter, chain.push(e))
+		if err != nil {
+			return nil, err
+		}
+		if !summary.isEmpty() {
+			e.summary = &summary
+		}
+	}
+
+	dir.sortEntries(opts.sortBy)
+
+	return dir, nil
+}
+
+func (d *dirListing) childPath(i int) string {
+	return path.Join(d.path, d.entries[i].name)
+}
+
+func (d *dirListing) indexOf(name string) int {
+	for i, e := range d.entries {
+		if e.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (d *dirListing) childrenPruned(opts *options) bool {
+	return opts.maxDepth > 0 && d.level+1 >= opts.maxDepth
+}
+
+// canDescend reports whether the walk goes into the i-th entry.
+func (d *dirListing) canDescend(i int, opts *options) bool {
+	e := d.entries[i]
+	return e.isDir && !e.recursive && !d.childrenPruned(opts)
+}
+
+func (d *dirListing) loadChild(i int, opts *options) (*dirListing, error) {
+	return loadDir(d.fsys, d.childPath(i), opts, d.filters[i], d.level+1, d.chain.push(d.entries[i]))
+}
+
+// prefetch loads the whole subtree below dir, reading sibling directories
+// concurrently. Subdirectories that are already loaded are kept. sem
+// bounds the number of directories read at once, the first error stops
+// the remaining reads and is returned.
+func prefetch(dir *dirListing, opts *options, sem chan struct{}) error {
+	wg := &sync.WaitGroup{}
+	mu := &sync.Mutex{}
+	var firstErr error
+
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	var load func(dir *dirListing, i int)
+	var loadChildren func(dir *dirListing)
+	load = func(dir *dirListing, i int) {
+		defer wg.Done()
+
+		sem <- struct{}{}
+		if failed() {
+			<-sem
+			return
+		}
+		sub, err := dir.loadChild(i, opts)
+		<-sem
+
+		if err != nil {
+			fail(err)
+			return
+		}
+		dir.children[i] = sub
+		loadChildren(sub)
+	}
+
+	loadChildren = func(dir *dirListing) {
+		for i := range dir.entries {
+			if !dir.canDescend(i, opts) {
+				continue
+			}
+			if dir.children[i] != nil {
+				loadChildren(dir.children[i])
+				continue
+			}
+			wg.Add(1)
+			go load(dir, i)
+		}
+	}
+
+	loadChildren(dir)
+	wg.Wait()
+
+	return firstErr
+}
+
+// aggregateSizes replaces the size of every directory below dir with the
+// total size of the files inside it. It needs the whole tree prefetched.
+func aggregateSizes(dir *dirListing, opts *options) int64 {
+	total := dir.filesSize
+
+	for i, e := range dir.entries {
+		if !e.isDir {
+			continue
+		}
+
+		switch {
+		case dir.children[i] != nil:
+			e.size = aggregateSizes(dir.children[i], opts)
+		case e.summary != nil:
+			e.size = e.summary.size
+		default:
+			e.size = 0
+		}
+		total += e.size
+	}
+
+	if opts.sortBy == "size" {
+		dir.sortEntries(opts.sortBy)
+	}
+
+	return total
+}
+
+// sortEntries reorders entries together with their filters and children.
+// Entries come sorted by name, so the sort is stable to break ties by name.
+func (d *dirListing) sortEntries(sortBy string) {
+	less := entryLess(sortBy)
+	if less == nil {
+		return
+	}
+	sort.Stable(&listingSort{dir: d, less: less})
+}
+
+type listingSort struct {
+	dir  *dirListing
+	less func(a, b *entry) bool
+}
+
+func (s *listingSort) Len() int {
+	return len(s.dir.entries)
+}
+
+func (s *listingSort) Less(i, j int) bool {
+	return s.less(s.dir.entries[i], s.dir.entries[j])
+}
+
+func (s *listingSort) Swap(i, j int) {
+	d := s.dir
+	d.entries[i], d.entries[j] = d.entries[j], d.entries[i]
+	d.filters[i], d.filters[j] = d.filters[j], d.filters[i]
+	d.children[i], d.children[j] = d.children[j], d.children[i]
+}
+
+func entryLess(sortBy string) func(a, b *entry) bool {
+	switch sortBy {
+	case "size":
+		return func(a, b *entry) bool {
+			return a.size > b.size
+		}
+	case "mtime":
+		return func(a, b *entry) bool {
+			return a.modTime.After(b.modTime)
+		}
+	case "type":
+		return func(a, b *entry) bool {
+			return a.isDir && !b.isDir
+		}
+	default:
+		return nil
+	}
+}
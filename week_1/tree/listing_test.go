@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTreeParallelMatchesSequential(t *testing.T) {
+	argsList := [][]string{
+		{"-f"},
+		{},
+		{"-f", "-L", "2"},
+		{"-f", "-J"},
+		{"--exclude", "ipsum", "--format", "ndjson"},
+	}
+
+	for _, args := range argsList {
+		sequential := runTree(t, "testdata", args...)
+		for _, workers := range []string{"2", "8"} {
+			parallel := runTree(t, "testdata", append(args, "-j", workers)...)
+			if parallel != sequential {
+				t.Errorf("args %v -j %s: results not match\nGot:\n%v\nExpected:\n%v", args, workers, parallel, sequential)
+			}
+		}
+	}
+}
+
+func TestTreeSubdirErrorPropagates(t *testing.T) {
+	root := makeTree(t, map[string]string{
+		"a/b/file.txt": "x",
+	})
+	// a directory named .gitignore cannot be read as a rules file
+	if err := os.MkdirAll(filepath.Join(root, "a", "b", "c", ".gitignore"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, workers := range []string{"1", "4"} {
+		_, opts, err := parseArgs([]string{root, "-f", "--gitignore", "-j", workers})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = dirTreeWithOptions(new(bytes.Buffer), root, opts)
+		if err == nil || !strings.Contains(err.Error(), "is a directory") {
+			t.Errorf("-j %s: expected error from nested directory, got %v", workers, err)
+		}
+	}
+}
@@ -1,112 +1,254 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"os/signal"
 	"sort"
-	"strconv"
 )
 
 func main() {
 	out := os.Stdout
-	if !(len(os.Args) == 2 || len(os.Args) == 3) {
-		panic("usage go run main.go . [-f]")
+
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		oldPath, newPath, opts, err := parseDiffArgs(os.Args[2:])
+		if err != nil {
+			panic("usage go run . diff OLD NEW [-f] [--hash] [--changed-only] [options]: " + err.Error())
+		}
+		changed, err := dirTreeDiff(out, oldPath, newPath, opts)
+		if err != nil {
+			panic(err.Error())
+		}
+		if changed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	path, opts, err := parseArgs(os.Args[1:])
+	if err != nil {
+		panic("usage go run . . [-f] [options], see readme.md: " + err.Error())
+	}
+	if opts.watch {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		err = watchTree(ctx, out, path, opts)
+		if err != nil {
+			panic(err.Error())
+		}
+		return
 	}
-	path := os.Args[1]
-	printFiles := len(os.Args) == 3 && os.Args[2] == "-f"
-	err := dirTree(out, path, printFiles)
+	err = dirTreeWithOptions(out, path, opts)
 	if err != nil {
 		panic(err.Error())
 	}
 }
 
 func dirTree(out io.Writer, path string, printFiles bool) error {
+	return dirTreeWithOptions(out, path, &options{printFiles: printFiles})
+}
+
+func dirTreeWithOptions(out io.Writer, path string, opts *options) error {
+	src, err := openTree(path)
+
+	if err != nil {
+		return fmt.Errorf("error open: %v", err)
+	}
+
+	defer src.Close()
+
+	return renderTree(out, src, path, opts)
+}
+
+// dirTreeFS renders fsys starting from its root, e.g. an embed.FS or an
+// fstest.MapFS.
+func dirTreeFS(out io.Writer, fsys fs.FS, opts *options) error {
+	return renderTree(out, fsys, ".", opts)
+}
 
-	err := walk(out, path, printFiles, "", 0)
+func renderTree(out io.Writer, fsys fs.FS, rootName string, opts *options) error {
+	r, err := newRenderer(out, opts)
+
+	if err != nil {
+		return fmt.Errorf("error renderer: %v", err)
+	}
+
+	root, dir, err := loadTree(fsys, rootName, opts, opts.workers > 1 || opts.du || opts.stats)
+
+	if err != nil {
+		return err
+	}
+
+	err = r.begin(root)
+
+	if err != nil {
+		return fmt.Errorf("error render: %v", err)
+	}
+
+	err = walk(r, dir, opts)
 
 	if err != nil {
 		return fmt.Errorf("error walk: %v", err)
 	}
 
-	return nil
+	return r.end()
 }
 
-func walk(out io.Writer, path string, printFiles bool, indentation string, level int) error {
-	vertical := "│"
-	tab := "\t"
-	lastElIndentation := "└───"
-	elIndentation := "├───"
-	files, err := os.ReadDir(path)
+// loadTree reads the root directory of fsys. With full set the whole tree
+// is read ahead, which --du, --stats and the diff mode need.
+func loadTree(fsys fs.FS, rootName string, opts *options, full bool) (*entry, *dirListing, error) {
+	filter, err := newEntryFilter(opts).enter(fsys, "")
 
 	if err != nil {
-		return fmt.Errorf("error: %v", err)
+		return nil, nil, fmt.Errorf("error filter: %v", err)
 	}
 
-	if !printFiles {
-		files = filterDirr(files)
+	root, err := newRootEntry(fsys, rootName)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("error root: %v", err)
 	}
 
-	sort.Slice(files, func(i, j int) bool {
-		return files[i].Name() < files[j].Name()
-	})
+	var chain *dirChain
+	dir, err := loadDir(fsys, ".", opts, filter, 0, chain.push(root))
 
-	lenFiles := len(files)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error walk: %v", err)
+	}
 
-	for i, file := range files {
-		el := ""
-		size := ""
-		if i == lenFiles-1 {
-			el = lastElIndentation
-		} else {
-			el = elIndentation
-		}
+	if !full {
+		return root, dir, nil
+	}
 
-		size, err = getFormatedFileSize(file)
+	err = prefetch(dir, opts, workerSem(opts))
 
+	if err != nil {
+		return nil, nil, fmt.Errorf("error walk: %v", err)
+	}
+
+	aggregate(root, dir, opts)
+
+	return root, dir, nil
+}
+
+func workerSem(opts *options) chan struct{} {
+	workers := opts.workers
+	if workers < 1 {
+		workers = 1
+	}
+	return make(chan struct{}, workers)
+}
+
+// aggregate fills in the directory totals of a fully loaded tree.
+func aggregate(root *entry, dir *dirListing, opts *options) {
+	if opts.du {
+		aggregateSizes(dir, opts)
+	}
+
+	if opts.stats {
+		root.stats = aggregateStats(dir)
+	}
+}
+
+func walk(r renderer, dir *dirListing, opts *options) error {
+	lenEntries := len(dir.entries)
+
+	for i, e := range dir.entries {
+		isLast := i == lenEntries-1
+
+		if !e.isDir {
+			err := r.file(e, isLast)
+			if err != nil {
+				return fmt.Errorf("render error %v", err)
+			}
+			continue
+		}
+
+		err := r.openDir(e, isLast)
 		if err != nil {
-			return fmt.Errorf("get file size error %v", err)
+			return fmt.Errorf("render error %v", err)
 		}
-		fmt.Fprintln(out, indentation+el+file.Name()+size)
 
-		if file.IsDir() {
-			newLevel := level + 1
-			if i == lenFiles-1 {
-				vertical = ""
+		if dir.canDescend(i, opts) {
+			sub := dir.children[i]
+			if sub == nil {
+				sub, err = dir.loadChild(i, opts)
+				if err != nil {
+					return err
+				}
 			}
-			newIndentation := indentation + vertical + tab
-			walk(out, path+"/"+file.Name(), printFiles, newIndentation, newLevel)
+
+			err = walk(r, sub, opts)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = r.closeDir(e)
+		if err != nil {
+			return fmt.Errorf("render error %v", err)
 		}
 	}
 	return nil
 }
 
-func filterDirr(files []os.DirEntry) []os.DirEntry {
-	acc := []os.DirEntry{}
-	for _, file := range files {
-		if file.IsDir() {
-			acc = append(acc, file)
-		}
+// readEntries lists the entries of dir that will be shown, sorted by name.
+// With --du or --stats it also returns the files in path that pass the
+// filter, whether they are shown or not.
+func readEntries(fsys fs.FS, dir string, opts *options, filter *entryFilter) ([]*entry, []*entry, error) {
+	dirsOnly := !opts.printFiles && !opts.du && !opts.stats
+	entries, err := readDirEntries(fsys, dir, opts.followSymlinks, dirsOnly)
+
+	if err != nil {
+		return nil, nil, err
 	}
-	return acc
-}
 
-func getFormatedFileSize(file os.DirEntry) (string, error) {
-	if file.IsDir() {
-		return "", nil
+	files := []*entry{}
+
+	if opts.du || opts.stats {
+		files = filterFiles(entries, filter)
 	}
-	info, err := file.Info()
 
-	if err != nil {
-		return "", err
+	if !opts.printFiles {
+		entries = filterDirr(entries)
 	}
-	size := ""
 
-	if info.Size() == 0 {
-		size = "empty"
-	} else {
-		size = strconv.FormatInt(info.Size(), 10) + "b"
+	entries = filter.apply(entries, opts.printFiles)
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+
+	return entries, files, nil
+}
+
+func filterFiles(entries []*entry, filter *entryFilter) []*entry {
+	acc := []*entry{}
+	for _, e := range entries {
+		if !e.isDir && filter.allow(e.name, false, true) {
+			acc = append(acc, e)
+		}
+	}
+	return acc
+}
+
+func getFilesSize(entries []*entry) int64 {
+	size := int64(0)
+	for _, e := range entries {
+		size += e.size
 	}
+	return size
+}
 
-	return " " + "(" + size + ")", nil
+func filterDirr(entries []*entry) []*entry {
+	acc := []*entry{}
+	for _, e := range entries {
+		if e.isDir {
+			acc = append(acc, e)
+		}
+	}
+	return acc
 }
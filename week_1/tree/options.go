@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type options struct {
+	printFiles bool
+	include    []string
+	exclude    []string
+	gitignore  bool
+	maxDepth   int
+	format     string
+	workers    int
+	humanSizes bool
+	du         bool
+	sortBy     string
+	stats      bool
+
+	followSymlinks bool
+	showPerms      bool
+	showOwner      bool
+	showGroup      bool
+	showMtime      bool
+
+	hash        bool
+	changedOnly bool
+
+	watch    bool
+	poll     bool
+	interval time.Duration
+}
+
+func parseArgs(args []string) (string, *options, error) {
+	paths, opts, err := parseFlags(args)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(paths) != 1 {
+		return "", nil, fmt.Errorf("expected one path, got %d", len(paths))
+	}
+	return paths[0], opts, nil
+}
+
+func parseDiffArgs(args []string) (string, string, *options, error) {
+	paths, opts, err := parseFlags(args)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if len(paths) != 2 {
+		return "", "", nil, fmt.Errorf("expected two paths, got %d", len(paths))
+	}
+	return paths[0], paths[1], opts, nil
+}
+
+func parseFlags(args []string) ([]string, *options, error) {
+	opts := &options{interval: time.Second}
+	paths := []string{}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+
+		switch name {
+		case "-f":
+			opts.printFiles = true
+		case "--gitignore":
+			opts.gitignore = true
+		case "--include", "--exclude":
+			value, err := flagValue(args, &i, name, value, hasValue)
+			if err != nil {
+				return nil, nil, err
+			}
+			if _, err := path.Match(value, ""); err != nil {
+				return nil, nil, fmt.Errorf("bad pattern %q: %v", value, err)
+			}
+			if name == "--include" {
+				opts.include = append(opts.include, value)
+			} else {
+				opts.exclude = append(opts.exclude, value)
+			}
+		case "-L":
+			value, err := flagValue(args, &i, name, value, hasValue)
+			if err != nil {
+				return nil, nil, err
+			}
+			depth, err := strconv.Atoi(value)
+			if err != nil || depth < 1 {
+				return nil, nil, fmt.Errorf("bad depth %q", value)
+			}
+			opts.maxDepth = depth
+		case "--format":
+			value, err := flagValue(args, &i, name, value, hasValue)
+			if err != nil {
+				return nil, nil, err
+			}
+			opts.format = value
+		case "-j", "--workers":
+			value, err := flagValue(args, &i, name, value, hasValue)
+			if err != nil {
+				return nil, nil, err
+			}
+			workers, err := strconv.Atoi(value)
+			if err != nil || workers < 1 {
+				return nil, nil, fmt.Errorf("bad workers count %q", value)
+			}
+			opts.workers = workers
+		case "-h":
+			opts.humanSizes = true
+		case "--du":
+			opts.du = true
+		case "--stats":
+			opts.stats = true
+		case "--sort":
+			value, err := flagValue(args, &i, name, value, hasValue)
+			if err != nil {
+				return nil, nil, err
+			}
+			if entryLess(value) == nil && value != "name" {
+				return nil, nil, fmt.Errorf("unknown sort %q", value)
+			}
+			opts.sortBy = value
+		case "-l", "--follow-symlinks":
+			opts.followSymlinks = true
+		case "-p":
+			opts.showPerms = true
+		case "-u":
+			opts.showOwner = true
+		case "-g":
+			opts.showGroup = true
+		case "-D":
+			opts.showMtime = true
+		case "--hash":
+			opts.hash = true
+		case "--changed-only":
+			opts.changedOnly = true
+		case "--watch":
+			opts.watch = true
+		case "--poll":
+			opts.poll = true
+		case "--interval":
+			value, err := flagValue(args, &i, name, value, hasValue)
+			if err != nil {
+				return nil, nil, err
+			}
+			interval, err := time.ParseDuration(value)
+			if err != nil || interval <= 0 {
+				return nil, nil, fmt.Errorf("bad interval %q", value)
+			}
+			opts.interval = interval
+		case "-J":
+			opts.format = "json"
+		case "-X":
+			opts.format = "xml"
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return nil, nil, fmt.Errorf("unknown flag %s", arg)
+			}
+			paths = append(paths, arg)
+		}
+	}
+
+	return paths, opts, nil
+}
+
+func flagValue(args []string, i *int, name string, value string, hasValue bool) (string, error) {
+	if hasValue {
+		return value, nil
+	}
+	if *i+1 >= len(args) {
+		return "", fmt.Errorf("flag %s needs a value", name)
+	}
+	*i++
+	return args[*i], nil
+}
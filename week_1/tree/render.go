@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// renderer receives the walk as a stream of events: files, and directories
+// as an openDir/closeDir pair around their children. The root itself is
+// passed to begin and is not reported as a directory.
+type renderer interface {
+	begin(root *entry) error
+	file(e *entry, isLast bool) error
+	openDir(e *entry, isLast bool) error
+	closeDir(e *entry) error
+	end() error
+}
+
+func newRenderer(out io.Writer, opts *options) (renderer, error) {
+	switch opts.format {
+	case "", "text":
+		return &textRenderer{out: out, opts: opts}, nil
+	case "json":
+		return &jsonRenderer{out: out, opts: opts}, nil
+	case "ndjson":
+		return &ndjsonRenderer{out: out, opts: opts}, nil
+	case "xml":
+		return &xmlRenderer{out: out, opts: opts}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %s", opts.format)
+	}
+}
+
+type textRenderer struct {
+	out         io.Writer
+	opts        *options
+	indentation []string
+	root        *entry
+}
+
+func (t *textRenderer) begin(root *entry) error {
+	t.indentation = []string{""}
+	t.root = root
+	return nil
+}
+
+func (t *textRenderer) file(e *entry, isLast bool) error {
+	line := t.prefix(isLast) + statusMarker(e) + t.columns(e) + e.name
+	if e.isLink {
+		line += " -> " + e.linkTarget
+	}
+	if !e.isLink || e.followed {
+		line += withStats(getFormatedFileSize(e.size, t.opts.humanSizes), e)
+	}
+	if len(e.changes) > 0 {
+		line += " [" + strings.Join(e.changes, ", ") + "]"
+	}
+	_, err := fmt.Fprintln(t.out, line)
+	return err
+}
+
+func (t *textRenderer) openDir(e *entry, isLast bool) error {
+	line := t.prefix(isLast) + statusMarker(e) + t.columns(e) + e.name
+	if e.isLink {
+		line += " -> " + e.linkTarget
+	}
+	if e.recursive {
+		line += " [recursive, not followed]"
+	} else if e.summary != nil {
+		line += "/ " + e.summary.format(t.opts.humanSizes)
+	} else if t.opts.du {
+		line += withStats(getFormatedFileSize(e.size, t.opts.humanSizes), e)
+	} else if e.stats != nil {
+		line += " (" + e.stats.String() + ")"
+	}
+
+	vertical := "│"
+	if isLast {
+		vertical = ""
+	}
+	t.indentation = append(t.indentation, t.current()+vertical+"\t")
+
+	_, err := fmt.Fprintln(t.out, line)
+	return err
+}
+
+func (t *textRenderer) closeDir(e *entry) error {
+	t.indentation = t.indentation[:len(t.indentation)-1]
+	return nil
+}
+
+func (t *textRenderer) end() error {
+	if t.root.stats == nil {
+		return nil
+	}
+	return writeStatsReport(t.out, t.root.stats)
+}
+
+// withStats adds the line count and language of a file, or the totals of
+// a directory, to its size label.
+func withStats(label string, e *entry) string {
+	if e.stats == nil {
+		return label
+	}
+	if e.isDir {
+		return strings.TrimSuffix(label, ")") + ", " + e.stats.String() + ")"
+	}
+	return strings.TrimSuffix(label, ")") + ", " + plural(e.stats.lines, "line") + ", " + e.stats.language() + ")"
+}
+
+func statusMarker(e *entry) string {
+	switch e.status {
+	case statusAdded:
+		return "[+] "
+	case statusRemoved:
+		return "[-] "
+	case statusChanged:
+		return "[~] "
+	default:
+		return ""
+	}
+}
+
+// columns renders the metadata requested with -p, -u, -g and -D in the
+// same bracketed form tree uses.
+func (t *textRenderer) columns(e *entry) string {
+	parts := []string{}
+	if t.opts.showPerms {
+		parts = append(parts, e.mode.String())
+	}
+	if t.opts.showOwner {
+		parts = append(parts, e.owner())
+	}
+	if t.opts.showGroup {
+		parts = append(parts, e.group())
+	}
+	if t.opts.showMtime {
+		parts = append(parts, e.modTime.Format("2006-01-02 15:04"))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(parts, " ") + "] "
+}
+
+func (t *textRenderer) current() string {
+	return t.indentation[len(t.indentation)-1]
+}
+
+func (t *textRenderer) prefix(isLast bool) string {
+	if isLast {
+		return t.current() + "└───"
+	}
+	return t.current() + "├───"
+}
+
+func getFormatedFileSize(size int64, humanSizes bool) string {
+	formated := ""
+
+	if size == 0 {
+		formated = "empty"
+	} else {
+		formated = formatSize(size, humanSizes)
+	}
+
+	return " " + "(" + formated + ")"
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func renderFixture(t *testing.T) string {
+	return makeTree(t, map[string]string{
+		"a.txt":     "hello",
+		"dir/b.txt": "",
+		"dir/sub/":  "",
+	})
+}
+
+func TestTreeJSON(t *testing.T) {
+	root := renderFixture(t)
+	result := runTree(t, root, "-f", "-J")
+
+	var tree struct {
+		Name     string `json:"name"`
+		Type     string `json:"type"`
+		Children []struct {
+			Name     string `json:"name"`
+			Type     string `json:"type"`
+			Size     int64  `json:"size"`
+			Mode     string `json:"mode"`
+			Mtime    string `json:"mtime"`
+			Children []struct {
+				Name string `json:"name"`
+				Type string `json:"type"`
+			} `json:"children"`
+		} `json:"children"`
+	}
+	if err := json.Unmarshal([]byte(result), &tree); err != nil {
+		t.Fatalf("invalid json %v:\n%s", err, result)
+	}
+
+	if tree.Name != root || tree.Type != "directory" || len(tree.Children) != 2 {
+		t.Fatalf("unexpected root: %s", result)
+	}
+	file := tree.Children[0]
+	if file.Name != "a.txt" || file.Type != "file" || file.Size != 5 || file.Mode != "-rw-r--r--" || file.Mtime != "2024-05-01T12:00:00Z" {
+		t.Errorf("unexpected file record: %+v", file)
+	}
+	dir := tree.Children[1]
+	if dir.Name != "dir" || dir.Type != "directory" || len(dir.Children) != 2 || dir.Children[1].Name != "sub" {
+		t.Errorf("unexpected dir record: %+v", dir)
+	}
+}
+
+func TestTreeNDJSON(t *testing.T) {
+	root := renderFixture(t)
+	result := runTree(t, root, "-f", "--format", "ndjson")
+
+	expected := []string{
+		`{"path":"a.txt","name":"a.txt","type":"file","size":5,"mode":"-rw-r--r--","mtime":"2024-05-01T12:00:00Z"}`,
+		`{"path":"dir","name":"dir","type":"directory",`,
+		`{"path":"dir/b.txt","name":"b.txt","type":"file","size":0,"mode":"-rw-r--r--","mtime":"2024-05-01T12:00:00Z"}`,
+		`{"path":"dir/sub","name":"sub","type":"directory",`,
+	}
+	lines := strings.Split(strings.TrimSuffix(result, "\n"), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, got:\n%s", len(expected), result)
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, expected[i]) {
+			t.Errorf("line %d does not match\nGot:\n%v\nExpected:\n%v", i, line, expected[i])
+		}
+	}
+}
+
+func TestTreeXML(t *testing.T) {
+	root := renderFixture(t)
+	result := runTree(t, root, "-X", "-L", "1")
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<tree>
+  <directory name="ROOT" size="SIZE" mode="drwxr-xr-x" mtime="2024-05-01T12:00:00Z">
+    <directory name="dir" size="SIZE" mode="drwxr-xr-x" mtime="2024-05-01T12:00:00Z" dirs="1" files="1" total="0">
+    </directory>
+  </directory>
+  <report>
+    <directories>2</directories>
+    <files>1</files>
+  </report>
+</tree>
+`
+	result = strings.Replace(result, root, "ROOT", 1)
+	result = regexp.MustCompile(`(<directory name="[^"]*") size="\d+"`).ReplaceAllString(result, `$1 size="SIZE"`)
+	if result != expected {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testDuResult = `├───static (275.0KiB)
+│	├───a_lorem (137.4KiB)
+│	│	└───ipsum (68.7KiB)
+│	├───z_lorem (137.4KiB)
+│	│	└───ipsum (68.7KiB)
+│	├───html (57B)
+│	├───css (28B)
+│	└───js (10B)
+├───zline (137.4KiB)
+│	└───lorem (137.4KiB)
+│		└───ipsum (68.7KiB)
+└───project (68.7KiB)
+`
+
+func TestTreeDuSortBySize(t *testing.T) {
+	for _, workers := range []string{"1", "4"} {
+		result := runTree(t, "testdata", "--du", "-h", "--sort", "size", "-j", workers)
+		if result != testDuResult {
+			t.Errorf("-j %s: results not match\nGot:\n%v\nExpected:\n%v", workers, result, testDuResult)
+		}
+	}
+}
+
+func TestTreeDuPruned(t *testing.T) {
+	expected := `├───project (70391b)
+├───static (281583b)
+│	├───a_lorem/ (1 dir, 3 files, 140744b)
+│	├───css/ (0 dirs, 1 file, 28b)
+│	├───html/ (0 dirs, 1 file, 57b)
+│	├───js/ (0 dirs, 1 file, 10b)
+│	└───z_lorem/ (1 dir, 3 files, 140744b)
+└───zline (140744b)
+	└───lorem/ (1 dir, 3 files, 140744b)
+`
+	result := runTree(t, "testdata", "--du", "-L", "2")
+	if result != expected {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+
+	expected = `├───project (68.7KiB)
+├───static (275.0KiB)
+│	├───a_lorem/ (1 dir, 3 files, 137.4KiB)
+│	├───css/ (0 dirs, 1 file, 28B)
+│	├───html/ (0 dirs, 1 file, 57B)
+│	├───js/ (0 dirs, 1 file, 10B)
+│	└───z_lorem/ (1 dir, 3 files, 137.4KiB)
+└───zline (137.4KiB)
+	└───lorem/ (1 dir, 3 files, 137.4KiB)
+`
+	result = runTree(t, "testdata", "--du", "-h", "-L", "2")
+	if result != expected {
+		t.Errorf("-h: results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+}
+
+func TestTreeSortByMtimeAndType(t *testing.T) {
+	root := makeTree(t, map[string]string{
+		"a.txt":   "a",
+		"b.txt":   "b",
+		"c/d.txt": "d",
+		"e.txt":   "e",
+		"f/":      "",
+	})
+	newer := testModTime.Add(time.Hour)
+	for _, name := range []string{"b.txt", "f"} {
+		if err := os.Chtimes(filepath.Join(root, name), newer, newer); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := `├───b.txt (1b)
+├───f
+├───a.txt (1b)
+├───c
+│	└───d.txt (1b)
+└───e.txt (1b)
+`
+	result := runTree(t, root, "-f", "--sort", "mtime")
+	if result != expected {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+
+	expected = `├───c
+│	└───d.txt (1b)
+├───f
+├───a.txt (1b)
+├───b.txt (1b)
+└───e.txt (1b)
+`
+	result = runTree(t, root, "-f", "--sort", "type")
+	if result != expected {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// treeSource is a file system opened from a command line path: a
+// directory, or a .zip or .tar(.gz) archive rendered as if it was one.
+type treeSource interface {
+	fs.FS
+	Close() error
+}
+
+func openTree(root string) (treeSource, error) {
+	info, err := os.Stat(root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return newDirFS(root), nil
+	}
+
+	switch {
+	case strings.HasSuffix(root, ".zip"):
+		return zip.OpenReader(root)
+	case strings.HasSuffix(root, ".tar.gz"), strings.HasSuffix(root, ".tgz"):
+		return openTar(root, true)
+	case strings.HasSuffix(root, ".tar"):
+		return openTar(root, false)
+	default:
+		return nil, fmt.Errorf("%s is not a directory or a supported archive", root)
+	}
+}
+
+// dirFS is os.DirFS that can also read symlinks.
+type dirFS struct {
+	fs.FS
+	dir string
+}
+
+func newDirFS(dir string) *dirFS {
+	return &dirFS{FS: os.DirFS(dir), dir: dir}
+}
+
+func (d *dirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(d.FS, name)
+}
+
+func (d *dirFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(d.FS, name)
+}
+
+func (d *dirFS) ReadLink(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return os.Readlink(d.dir + "/" + name)
+}
+
+func (d *dirFS) Close() error {
+	return nil
+}
+
+type readLinkFS interface {
+	ReadLink(name string) (string, error)
+}
+
+// readLink returns the target of a symlink, or an empty string when fsys
+// has no way to tell it.
+func readLink(fsys fs.FS, name string) (string, error) {
+	if rl, ok := fsys.(readLinkFS); ok {
+		return rl.ReadLink(name)
+	}
+	return "", nil
+}
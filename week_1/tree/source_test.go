@@ -0,0 +1,207 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"embed"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+//go:embed testdata
+var embeddedTestdata embed.FS
+
+func TestTreeEmbedFS(t *testing.T) {
+	fsys, err := fs.Sub(embeddedTestdata, "testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	err = dirTreeFS(out, fsys, &options{printFiles: true})
+	if err != nil {
+		t.Fatalf("dirTreeFS: %v", err)
+	}
+	if out.String() != testFullResult {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", out.String(), testFullResult)
+	}
+}
+
+func TestTreeMapFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a/b/c.txt":    {Data: []byte("abc")},
+		"a/empty":      {},
+		"a/.gitignore": {Data: []byte("*.log\n")},
+		"a/debug.log":  {Data: []byte("log")},
+		"d":            {Mode: fs.ModeDir},
+	}
+
+	expected := `├───a
+│	├───.gitignore (6b)
+│	├───b
+│	│	└───c.txt (3b)
+│	└───empty (empty)
+└───d
+`
+	out := new(bytes.Buffer)
+	err := dirTreeFS(out, fsys, &options{printFiles: true, gitignore: true})
+	if err != nil {
+		t.Fatalf("dirTreeFS: %v", err)
+	}
+	if out.String() != expected {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", out.String(), expected)
+	}
+}
+
+const testArchiveResult = `├───docs
+│	└───readme.md (6b)
+└───main.go (12b)
+`
+
+func TestTreeZip(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "src.zip")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, file := range []struct{ name, data string }{
+		{"main.go", "package main"},
+		{"docs/readme.md", "# docs"},
+	} {
+		w, err := zw.Create(file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(file.data))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	result := runTree(t, name, "-f")
+	if result != testArchiveResult {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", result, testArchiveResult)
+	}
+}
+
+func TestTreeTarGz(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "src.tar.gz")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	headers := []*tar.Header{
+		{Name: "./docs/", Typeflag: tar.TypeDir, Mode: 0o755, Uname: "alice"},
+		{Name: "./docs/readme.md", Typeflag: tar.TypeReg, Mode: 0o644, Size: 6, Uname: "alice"},
+		{Name: "./main.go", Typeflag: tar.TypeReg, Mode: 0o644, Size: 12, Uname: "bob"},
+	}
+	data := map[string]string{"./docs/readme.md": "# docs", "./main.go": "package main"}
+	for _, hdr := range headers {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(data[hdr.Name]))
+	}
+	tw.Close()
+	gz.Close()
+	f.Close()
+
+	result := runTree(t, name, "-f")
+	if result != testArchiveResult {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", result, testArchiveResult)
+	}
+
+	expected := `├───[alice] docs
+│	└───[alice] readme.md (6b)
+└───[bob] main.go (12b)
+`
+	result = runTree(t, name, "-f", "-u")
+	if result != expected {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+}
+
+type tarEntry struct {
+	hdr  tar.Header
+	data string
+}
+
+func writeTar(t *testing.T, name string, gzipped bool, entries []tarEntry) {
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var tw *tar.Writer
+	if gzipped {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		tw = tar.NewWriter(gz)
+	} else {
+		tw = tar.NewWriter(f)
+	}
+	for _, e := range entries {
+		e.hdr.Mode, e.hdr.Size = 0o644, int64(len(e.data))
+		if err := tw.WriteHeader(&e.hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(e.data))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTreeTarContents(t *testing.T) {
+	// the archive order is not the tree order, so a compressed archive is
+	// read from the start again
+	entries := []tarEntry{
+		{tar.Header{Name: "src/z.go", Typeflag: tar.TypeReg}, "package z\n\nvar Z = 1\n"},
+		{tar.Header{Name: "src/lib/a.go", Typeflag: tar.TypeReg}, "package lib\n"},
+		{tar.Header{Name: "notes.txt", Typeflag: tar.TypeReg}, "a\nb\n"},
+		{tar.Header{Name: "copy.txt", Typeflag: tar.TypeLink, Linkname: "notes.txt"}, ""},
+		{tar.Header{Name: "src/current", Typeflag: tar.TypeSymlink, Linkname: "lib"}, ""},
+		{tar.Header{Name: "latest.txt", Typeflag: tar.TypeSymlink, Linkname: "src/current/../../notes.txt"}, ""},
+	}
+
+	expected := `├───copy.txt (4b, 2 lines, Text)
+├───latest.txt -> src/current/../../notes.txt (4b, 2 lines, Text)
+├───notes.txt (4b, 2 lines, Text)
+└───src (2 files, 4 lines)
+	├───current -> lib
+	├───lib (1 file, 1 line)
+	│	└───a.go (12b, 1 line, Go)
+	└───z.go (21b, 3 lines, Go)
+`
+	for _, name := range []string{"src.tar", "src.tar.gz"} {
+		path := filepath.Join(t.TempDir(), name)
+		writeTar(t, path, name == "src.tar.gz", entries)
+
+		result := runTree(t, path, "-f", "--stats", "-l", "-j", "1")
+		if !strings.HasPrefix(result, expected) {
+			t.Errorf("%s: results not match\nGot:\n%v\nExpected:\n%v", name, result, expected)
+		}
+
+		fsys, err := openTar(path, name == "src.tar.gz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fstest.TestFS(fsys, "notes.txt", "copy.txt", "src/lib/a.go", "src/z.go"); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if data, err := fs.ReadFile(fsys, "src/current/a.go"); err != nil || string(data) != "package lib\n" {
+			t.Errorf("%s: read through a symlink: %q, %v", name, data, err)
+		}
+		fsys.Close()
+	}
+}
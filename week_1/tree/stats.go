@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+var languages = map[string]string{
+	".c":     "C",
+	".cc":    "C++",
+	".cpp":   "C++",
+	".css":   "CSS",
+	".go":    "Go",
+	".h":     "C/C++ Header",
+	".htm":   "HTML",
+	".html":  "HTML",
+	".java":  "Java",
+	".js":    "JavaScript",
+	".json":  "JSON",
+	".md":    "Markdown",
+	".proto": "Protocol Buffers",
+	".py":    "Python",
+	".rs":    "Rust",
+	".sh":    "Shell",
+	".sql":   "SQL",
+	".ts":    "TypeScript",
+	".txt":   "Text",
+	".xml":   "XML",
+	".yaml":  "YAML",
+	".yml":   "YAML",
+}
+
+var languageFiles = map[string]string{
+	"dockerfile": "Dockerfile",
+	"go.mod":     "Go Module",
+	"go.sum":     "Go Module",
+	"makefile":   "Makefile",
+}
+
+// binarySniffLen is how much of a file is checked for NUL bytes, the same
+// heuristic git uses to tell binary files from text.
+const binarySniffLen = 8000
+
+func detectLanguage(name string) string {
+	if lang, ok := languageFiles[strings.ToLower(name)]; ok {
+		return lang
+	}
+	if lang, ok := languages[strings.ToLower(path.Ext(name))]; ok {
+		return lang
+	}
+	return "Other"
+}
+
+type languageStats struct {
+	files int
+	lines int
+}
+
+// contentStats counts text files and their lines per language, for one
+// file or for everything below a directory. Binary files are not counted.
+type contentStats struct {
+	files     int
+	lines     int
+	languages map[string]*languageStats
+}
+
+func newContentStats() *contentStats {
+	return &contentStats{languages: map[string]*languageStats{}}
+}
+
+func (s *contentStats) addFile(language string, lines int) {
+	s.files++
+	s.lines += lines
+	l := s.languages[language]
+	if l == nil {
+		l = &languageStats{}
+		s.languages[language] = l
+	}
+	l.files++
+	l.lines += lines
+}
+
+func (s *contentStats) add(other *contentStats) {
+	if other == nil {
+		return
+	}
+	s.files += other.files
+	s.lines += other.lines
+	for name, o := range other.languages {
+		l := s.languages[name]
+		if l == nil {
+			l = &languageStats{}
+			s.languages[name] = l
+		}
+		l.files += o.files
+		l.lines += o.lines
+	}
+}
+
+// language is the language of a single file's stats.
+func (s *contentStats) language() string {
+	for name := range s.languages {
+		return name
+	}
+	return ""
+}
+
+// sortedLanguages lists the languages with the most lines first.
+func (s *contentStats) sortedLanguages() []string {
+	names := make([]string, 0, len(s.languages))
+	for name := range s.languages {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := s.languages[names[i]], s.languages[names[j]]
+		if a.lines != b.lines {
+			return a.lines > b.lines
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// countFiles reads every file and sets its stats, it returns the totals of
+// the text files among them.
+func countFiles(fsys fs.FS, files []*entry) (*contentStats, error) {
+	total := newContentStats()
+
+	for _, e := range files {
+		if e.isLink && !e.followed {
+			continue
+		}
+
+		lines, binary, err := countLines(fsys, e.path)
+		if err != nil {
+			return nil, err
+		}
+
+		if binary {
+			continue
+		}
+
+		e.stats = newContentStats()
+		e.stats.addFile(detectLanguage(e.name), lines)
+		total.add(e.stats)
+	}
+
+	return total, nil
+}
+
+// countLines counts lines the way wc -l does, plus an unterminated last
+// line. A file with a NUL byte near the start is reported as binary.
+func countLines(fsys fs.FS, name string) (int, bool, error) {
+	f, err := fsys.Open(name)
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	defer f.Close()
+
+	buf := make([]byte, 32*1024)
+	lines := 0
+	read := 0
+	var last byte
+
+	for {
+		n, err := f.Read(buf)
+
+		if n > 0 {
+			if read < binarySniffLen {
+				sniff := buf[:n]
+				if len(sniff) > binarySniffLen-read {
+					sniff = sniff[:binarySniffLen-read]
+				}
+				if bytes.IndexByte(sniff, 0) >= 0 {
+					return 0, true, nil
+				}
+			}
+			read += n
+			lines += bytes.Count(buf[:n], []byte{'\n'})
+			last = buf[n-1]
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return 0, false, err
+		}
+	}
+
+	if read > 0 && last != '\n' {
+		lines++
+	}
+
+	return lines, false, nil
+}
+
+// aggregateStats sets the stats of every directory below dir to the totals
+// of the files inside it. It needs the whole tree prefetched.
+func aggregateStats(dir *dirListing) *contentStats {
+	total := newContentStats()
+	total.add(dir.filesStats)
+
+	for i, e := range dir.entries {
+		if !e.isDir {
+			continue
+		}
+
+		switch {
+		case dir.children[i] != nil:
+			e.stats = aggregateStats(dir.children[i])
+		case e.summary != nil:
+			e.stats = e.summary.stats
+		default:
+			e.stats = newContentStats()
+		}
+		total.add(e.stats)
+	}
+
+	return total
+}
+
+func (s *contentStats) String() string {
+	return plural(s.files, "file") + ", " + plural(s.lines, "line")
+}
+
+// writeStatsReport prints a cloc style table of the totals per language.
+func writeStatsReport(out io.Writer, s *contentStats) error {
+	width := len("Language")
+	for name := range s.languages {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+
+	_, err := fmt.Fprintf(out, "\n%-*s %8s %10s\n", width, "Language", "files", "lines")
+	if err != nil {
+		return err
+	}
+
+	for _, name := range s.sortedLanguages() {
+		l := s.languages[name]
+		_, err = fmt.Fprintf(out, "%-*s %8d %10d\n", width, name, l.files, l.lines)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(out, "%-*s %8d %10d\n", width, "Total", s.files, s.lines)
+	return err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestTreeStats(t *testing.T) {
+	root := makeTree(t, map[string]string{
+		"main.go":        "package main\n\nfunc main() {}\n",
+		"readme.md":      "# tree\nno newline at the end",
+		"Makefile":       "all:\n\tgo build\n",
+		"pkg/util.go":    "package pkg\n",
+		"pkg/logo.png":   "\x89PNG\r\n\x1a\n\x00\x00",
+		"pkg/empty.txt":  "",
+		"vendor/x/x.go":  "package x\n\nvar X = 1\n",
+		"docs/notes.txt": "a\nb\nc\n",
+	})
+
+	expected := `├───Makefile (15b, 2 lines, Makefile)
+├───docs (6b, 1 file, 3 lines)
+│	└───notes.txt (6b, 3 lines, Text)
+├───main.go (29b, 3 lines, Go)
+├───pkg (22b, 2 files, 1 line)
+│	├───empty.txt (empty, 0 lines, Text)
+│	├───logo.png (10b)
+│	└───util.go (12b, 1 line, Go)
+└───readme.md (28b, 2 lines, Markdown)
+
+Language    files      lines
+Go              2          4
+Text            2          3
+Makefile        1          2
+Markdown        1          2
+Total           6         11
+`
+	for _, workers := range []string{"1", "4"} {
+		result := runTree(t, root, "-f", "--stats", "--du", "--exclude", "vendor", "-j", workers)
+		if result != expected {
+			t.Errorf("-j %s: results not match\nGot:\n%v\nExpected:\n%v", workers, result, expected)
+		}
+	}
+
+	expected = `├───docs/ (0 dirs, 1 file, 6b, 3 lines)
+├───pkg/ (0 dirs, 3 files, 22b, 1 line)
+└───vendor/ (1 dir, 1 file, 21b, 3 lines)
+
+Language    files      lines
+Go              3          7
+Text            2          3
+Makefile        1          2
+Markdown        1          2
+Total           7         14
+`
+	result := runTree(t, root, "--stats", "-L", "1")
+	if result != expected {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+}
+
+func TestCountLines(t *testing.T) {
+	fsys := fstest.MapFS{
+		"empty":      {Data: []byte("")},
+		"one":        {Data: []byte("one")},
+		"two":        {Data: []byte("one\ntwo\n")},
+		"blank":      {Data: []byte("\n\n\n")},
+		"binary":     {Data: []byte("GIF89a\x00\x01")},
+		"late-nul":   {Data: append(make([]byte, binarySniffLen), 0, '\n')},
+		"crlf-lines": {Data: []byte("a\r\nb\r\n")},
+	}
+	for i := range fsys["late-nul"].Data[:binarySniffLen] {
+		fsys["late-nul"].Data[i] = 'x'
+	}
+
+	cases := []struct {
+		name   string
+		lines  int
+		binary bool
+	}{
+		{"empty", 0, false},
+		{"one", 1, false},
+		{"two", 2, false},
+		{"blank", 3, false},
+		{"binary", 0, true},
+		{"late-nul", 1, false},
+		{"crlf-lines", 2, false},
+	}
+
+	for _, c := range cases {
+		lines, binary, err := countLines(fsys, c.name)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if lines != c.lines || binary != c.binary {
+			t.Errorf("%s: got %d lines, binary %v, expected %d, %v", c.name, lines, binary, c.lines, c.binary)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"strconv"
+)
+
+type treeSummary struct {
+	dirs  int
+	files int
+	size  int64
+	stats *contentStats
+}
+
+// summarize counts everything below path that passes the filter, it is
+// used for directories cut off by the depth limit.
+func summarize(fsys fs.FS, dir string, opts *options, filter *entryFilter, chain *dirChain) (treeSummary, error) {
+	summary := treeSummary{}
+	entries, err := readDirEntries(fsys, dir, opts.followSymlinks, false)
+
+	if err != nil {
+		return summary, err
+	}
+
+	files := filterFiles(entries, filter)
+	summary.files = len(files)
+	summary.size = getFilesSize(files)
+
+	if opts.stats {
+		summary.stats, err = countFiles(fsys, files)
+		if err != nil {
+			return summary, err
+		}
+	}
+
+	for _, e := range entries {
+		if !e.isDir || !filter.allow(e.name, true, true) {
+			continue
+		}
+
+		summary.dirs++
+		if chain.contains(e) {
+			continue
+		}
+
+		subFilter, err := filter.enter(fsys, e.name)
+		if err != nil {
+			return summary, err
+		}
+
+		sub, err := summarize(fsys, e.path, opts, subFilter, chain.push(e))
+		if err != nil {
+			return summary, err
+		}
+		summary.dirs += sub.dirs
+		summary.files += sub.files
+		summary.size += sub.size
+		if summary.stats != nil {
+			summary.stats.add(sub.stats)
+		}
+	}
+
+	return summary, nil
+}
+
+func (s treeSummary) isEmpty() bool {
+	return s.dirs == 0 && s.files == 0
+}
+
+// format prints the summary with the size formatted like the file sizes.
+func (s treeSummary) format(humanSizes bool) string {
+	if s.stats != nil {
+		return fmt.Sprintf("(%s, %s, %s, %s)", plural(s.dirs, "dir"), plural(s.files, "file"), formatSize(s.size, humanSizes), plural(s.stats.lines, "line"))
+	}
+	return fmt.Sprintf("(%s, %s, %s)", plural(s.dirs, "dir"), plural(s.files, "file"), formatSize(s.size, humanSizes))
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, word)
+	}
+	return fmt.Sprintf("%d %ss", n, word)
+}
+
+// formatSize is the size in bytes, or in KiB, MiB and so on with -h.
+func formatSize(size int64, humanSizes bool) string {
+	if !humanSizes {
+		return strconv.FormatInt(size, 10) + "b"
+	}
+	return formatIECSize(size)
+}
+
+func formatIECSize(size int64) string {
+	return scaleSize(size, []string{"KiB", "MiB", "GiB", "TiB", "PiB"})
+}
+
+func scaleSize(size int64, units []string) string {
+	if size < 1024 {
+		return fmt.Sprintf("%dB", size)
+	}
+
+	value := float64(size) / 1024
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+
+	return fmt.Sprintf("%.1f%s", value, units[unit])
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+const testDepthResult = `├───project/ (0 dirs, 2 files, 70391b)
+├───static/ (7 dirs, 10 files, 281583b)
+├───zline/ (2 dirs, 4 files, 140744b)
+└───zzfile.txt (empty)
+`
+
+const testDepthHumanResult = `├───project/ (0 dirs, 2 files, 68.7KiB)
+├───static/ (7 dirs, 10 files, 275.0KiB)
+├───zline/ (2 dirs, 4 files, 137.4KiB)
+└───zzfile.txt (empty)
+`
+
+const testDepthDirResult = `├───project
+├───static
+│	├───a_lorem/ (1 dir, 3 files, 140744b)
+│	├───css/ (0 dirs, 1 file, 28b)
+│	├───html/ (0 dirs, 1 file, 57b)
+│	├───js/ (0 dirs, 1 file, 10b)
+│	└───z_lorem/ (1 dir, 3 files, 140744b)
+└───zline
+	└───lorem/ (1 dir, 3 files, 140744b)
+`
+
+func TestTreeDepth(t *testing.T) {
+	result := runTree(t, "testdata", "-f", "-L", "1")
+	if result != testDepthResult {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", result, testDepthResult)
+	}
+
+	result = runTree(t, "testdata", "-f", "-h", "-L", "1")
+	if result != testDepthHumanResult {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", result, testDepthHumanResult)
+	}
+
+	result = runTree(t, "testdata", "-L=2")
+	if result != testDepthDirResult {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", result, testDepthDirResult)
+	}
+}
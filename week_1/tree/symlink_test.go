@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func makeLinkTree(t *testing.T) string {
+	t.Helper()
+	root := makeTree(t, map[string]string{
+		"data/file.txt": "hello",
+		"docs/readme":   "doc",
+	})
+	links := map[string]string{
+		"data/loop":   "..",
+		"data/broken": "missing.txt",
+		"docs-link":   "docs",
+		"readme-link": "docs/readme",
+		"data/self":   ".",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Skipf("symlinks are not supported: %v", err)
+		}
+	}
+	return root
+}
+
+func TestTreeSymlinksNotFollowed(t *testing.T) {
+	root := makeLinkTree(t)
+
+	expected := `├───data
+│	├───broken -> missing.txt
+│	├───file.txt (5b)
+│	├───loop -> ..
+│	└───self -> .
+├───docs
+│	└───readme (3b)
+├───docs-link -> docs
+└───readme-link -> docs/readme
+`
+	result := runTree(t, root, "-f")
+	if result != expected {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+}
+
+func TestTreeFollowSymlinks(t *testing.T) {
+	root := makeLinkTree(t)
+
+	expected := `├───data
+│	├───broken -> missing.txt
+│	├───file.txt (5b)
+│	├───loop -> .. [recursive, not followed]
+│	└───self -> . [recursive, not followed]
+├───docs
+│	└───readme (3b)
+├───docs-link -> docs
+│	└───readme (3b)
+└───readme-link -> docs/readme (3b)
+`
+	for _, args := range [][]string{{"-f", "--follow-symlinks"}, {"-f", "-l", "-j", "4"}} {
+		result := runTree(t, root, args...)
+		if result != expected {
+			t.Errorf("%v: results not match\nGot:\n%v\nExpected:\n%v", args, result, expected)
+		}
+	}
+
+	expected = `├───data/ (2 dirs, 2 files, 16b)
+├───docs/ (0 dirs, 1 file, 3b)
+└───docs-link -> docs/ (0 dirs, 1 file, 3b)
+`
+	result := runTree(t, root, "-l", "-L", "1")
+	if result != expected {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+}
+
+func TestTreeMetadataColumns(t *testing.T) {
+	root := makeTree(t, map[string]string{
+		"dir/file.txt": "hello",
+	})
+	if err := os.Chmod(filepath.Join(root, "dir", "file.txt"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	owner, group := fileOwner(mustStat(t, filepath.Join(root, "dir")))
+	mtime := testModTime.Local().Format("2006-01-02 15:04")
+
+	expected := `└───[drwxr-xr-x ` + owner + ` ` + group + ` ` + mtime + `] dir
+	└───[-rw------- ` + owner + ` ` + group + ` ` + mtime + `] file.txt (5b)
+`
+	result := runTree(t, root, "-f", "-p", "-u", "-g", "-D")
+	if result != expected {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+
+	result = runTree(t, root, "-f", "-u", "--format", "ndjson")
+	if !strings.Contains(result, `"owner":"`+owner+`"`) {
+		t.Errorf("owner is missing in ndjson output:\n%s", result)
+	}
+}
+
+func mustStat(t *testing.T, path string) os.FileInfo {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
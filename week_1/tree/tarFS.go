@@ -0,0 +1,392 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tarFS is an index of a tar archive: only the headers are kept in memory,
+// file contents are read from the archive when a file is opened. A plain
+// tar is read at the offset of the file, a compressed one has to be
+// decompressed up to it, going back to the start for an earlier file.
+type tarFS struct {
+	archive *os.File
+	gzipped bool
+	files   map[string]*tarFile
+
+	mu     sync.Mutex
+	stream *tar.Reader
+	gz     *gzip.Reader
+	next   int
+}
+
+// tarFile is an archive entry or a directory implied by the paths of the
+// entries below it.
+type tarFile struct {
+	name     string
+	hdr      *tar.Header
+	mode     fs.FileMode
+	size     int64
+	modTime  time.Time
+	children []*tarFile
+
+	// data is the entry holding the contents, another one for hard links;
+	// index is its number in the archive and offset where its contents
+	// start in a plain tar
+	data   *tarFile
+	index  int
+	offset int64
+}
+
+func openTar(name string, gzipped bool) (*tarFS, error) {
+	f, err := os.Open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	t := &tarFS{archive: f, gzipped: gzipped}
+	err = t.index()
+
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *tarFS) index() error {
+	t.files = map[string]*tarFile{".": {name: ".", mode: fs.ModeDir | 0o555}}
+
+	var r io.Reader = t.archive
+	if t.gzipped {
+		gz, err := gzip.NewReader(t.archive)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+
+	for i := 0; ; i++ {
+		hdr, err := tr.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean(strings.TrimLeft(hdr.Name, "/"))
+		if name == "." || !fs.ValidPath(name) {
+			continue
+		}
+
+		file := &tarFile{
+			name:    path.Base(name),
+			hdr:     hdr,
+			mode:    fs.FileMode(hdr.Mode).Perm(),
+			modTime: hdr.ModTime,
+			index:   i,
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			file.mode |= fs.ModeDir
+		case tar.TypeSymlink:
+			file.mode |= fs.ModeSymlink
+			file.size = int64(len(hdr.Linkname))
+		case tar.TypeLink:
+			target, ok := t.files[path.Clean(strings.TrimLeft(hdr.Linkname, "/"))]
+			if ok && target.data != nil {
+				file.data, file.size = target.data, target.size
+			}
+		case tar.TypeReg:
+			file.data, file.size = file, hdr.Size
+			if !t.gzipped {
+				// tar reads no further than the header, the contents start
+				// where the archive is now
+				file.offset, err = t.archive.Seek(0, io.SeekCurrent)
+				if err != nil {
+					return err
+				}
+			}
+		default:
+			continue
+		}
+
+		t.add(name, file)
+	}
+
+	for _, file := range t.files {
+		sort.Slice(file.children, func(i, j int) bool {
+			return file.children[i].name < file.children[j].name
+		})
+	}
+
+	return nil
+}
+
+// add puts file at name, creating the directories above it. A later entry
+// with the same name replaces the earlier one, as when extracting.
+func (t *tarFS) add(name string, file *tarFile) {
+	if old, ok := t.files[name]; ok {
+		if old.mode.IsDir() && file.mode.IsDir() {
+			file.children = old.children
+		}
+		*old = *file
+		return
+	}
+
+	dir := path.Dir(name)
+	parent, ok := t.files[dir]
+	if !ok {
+		parent = &tarFile{name: path.Base(dir), mode: fs.ModeDir | 0o755}
+		t.add(dir, parent)
+	}
+
+	t.files[name] = file
+	parent.children = append(parent.children, file)
+}
+
+func (t *tarFS) Close() error {
+	if t.gz != nil {
+		t.gz.Close()
+	}
+	return t.archive.Close()
+}
+
+// lookup finds name following symlinks, the last element of name is not
+// followed with noFollow.
+func (t *tarFS) lookup(op string, name string, noFollow bool) (*tarFile, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	notExist := &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+
+	resolved := "."
+	elems := strings.Split(name, "/")
+	for hops := 0; len(elems) > 0; {
+		next := path.Join(resolved, elems[0])
+		elems = elems[1:]
+		file, ok := t.files[next]
+		if !ok {
+			return nil, notExist
+		}
+
+		if file.mode&fs.ModeSymlink == 0 || (len(elems) == 0 && noFollow) {
+			resolved = next
+			continue
+		}
+
+		hops++
+		target := path.Join(path.Dir(next), file.hdr.Linkname)
+		if hops > 40 || path.IsAbs(file.hdr.Linkname) || !fs.ValidPath(target) {
+			return nil, notExist
+		}
+		elems = append(strings.Split(target, "/"), elems...)
+		resolved = "."
+	}
+
+	return t.files[resolved], nil
+}
+
+func (t *tarFS) Open(name string) (fs.File, error) {
+	file, err := t.lookup("open", name, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if file.mode.IsDir() {
+		return &tarDir{info: file}, nil
+	}
+
+	if file.data == nil {
+		return &tarReader{info: file, Reader: strings.NewReader("")}, nil
+	}
+
+	if !t.gzipped {
+		return &tarReader{info: file, Reader: io.NewSectionReader(t.archive, file.data.offset, file.data.size)}, nil
+	}
+
+	data, err := t.readGzipped(file.data)
+
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return &tarReader{info: file, Reader: bytes.NewReader(data)}, nil
+}
+
+// readGzipped decompresses the archive up to file and reads its contents.
+// Files opened in archive order are read in a single pass.
+func (t *tarFS) readGzipped(file *tarFile) ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.stream == nil || t.next > file.index {
+		_, err := t.archive.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+		if t.gz == nil {
+			t.gz, err = gzip.NewReader(t.archive)
+		} else {
+			err = t.gz.Reset(t.archive)
+		}
+		if err != nil {
+			return nil, err
+		}
+		t.stream, t.next = tar.NewReader(t.gz), 0
+	}
+
+	for t.next <= file.index {
+		_, err := t.stream.Next()
+		if err != nil {
+			t.stream = nil
+			return nil, err
+		}
+		t.next++
+	}
+
+	return io.ReadAll(io.LimitReader(t.stream, file.size))
+}
+
+func (t *tarFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	file, err := t.lookup("readdir", name, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !file.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	return file.entries(), nil
+}
+
+func (t *tarFS) Stat(name string) (fs.FileInfo, error) {
+	file, err := t.lookup("stat", name, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// Lstat is Stat that does not follow a symlink at name.
+func (t *tarFS) Lstat(name string) (fs.FileInfo, error) {
+	file, err := t.lookup("lstat", name, true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func (t *tarFS) ReadLink(name string) (string, error) {
+	file, err := t.lookup("readlink", name, true)
+
+	if err != nil {
+		return "", err
+	}
+
+	if file.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+
+	return file.hdr.Linkname, nil
+}
+
+func (f *tarFile) entries() []fs.DirEntry {
+	entries := make([]fs.DirEntry, len(f.children))
+	for i, child := range f.children {
+		entries[i] = fs.FileInfoToDirEntry(child)
+	}
+	return entries
+}
+
+func (f *tarFile) Name() string       { return f.name }
+func (f *tarFile) Size() int64        { return f.size }
+func (f *tarFile) Mode() fs.FileMode  { return f.mode }
+func (f *tarFile) ModTime() time.Time { return f.modTime }
+func (f *tarFile) IsDir() bool        { return f.mode.IsDir() }
+
+// Sys is the tar header, nil for implied directories.
+func (f *tarFile) Sys() interface{} {
+	if f.hdr == nil {
+		return nil
+	}
+	return f.hdr
+}
+
+type tarReader struct {
+	io.Reader
+	info *tarFile
+}
+
+func (r *tarReader) Stat() (fs.FileInfo, error) {
+	return r.info, nil
+}
+
+func (r *tarReader) Close() error {
+	return nil
+}
+
+type tarDir struct {
+	info    *tarFile
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *tarDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *tarDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *tarDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		d.entries, d.read = d.info.entries(), true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+func (d *tarDir) Close() error {
+	return nil
+}
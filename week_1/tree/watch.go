@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// clearScreen moves the cursor home and clears the terminal before a new
+// text frame is drawn.
+const clearScreen = "\x1b[H\x1b[2J"
+
+// changes tells the watch loop what to re-read: everything, or only the
+// listed directories (paths relative to the root) whose entries changed.
+type changes struct {
+	all  bool
+	dirs []string
+}
+
+// changeSource blocks until something below the root may have changed.
+type changeSource interface {
+	next(ctx context.Context) (changes, error)
+	Close() error
+}
+
+// newChangeSource prefers inotify for plain directories. Archives, trees
+// with followed symlinks, --poll and platforms without inotify are polled.
+func newChangeSource(fsys fs.FS, root string, opts *options) changeSource {
+	if _, ok := fsys.(*dirFS); ok && !opts.poll && !opts.followSymlinks {
+		source, err := newInotifySource(root)
+		if err == nil {
+			return source
+		}
+	}
+	return &pollSource{interval: opts.interval}
+}
+
+// pollSource asks for a full re-read every interval, the frames are then
+// compared to find what changed.
+type pollSource struct {
+	interval time.Duration
+}
+
+func (p *pollSource) next(ctx context.Context) (changes, error) {
+	timer := time.NewTimer(p.interval)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return changes{}, ctx.Err()
+	case <-timer.C:
+		return changes{all: true}, nil
+	}
+}
+
+func (p *pollSource) Close() error {
+	return nil
+}
+
+// watchTree renders path and then draws a new frame after every change
+// until ctx is cancelled. A frame is the regular output of the tree with
+// the entries changed since the previous frame marked as in the diff mode.
+func watchTree(ctx context.Context, out io.Writer, path string, opts *options) error {
+	src, err := openTree(path)
+
+	if err != nil {
+		return fmt.Errorf("error open: %v", err)
+	}
+
+	defer src.Close()
+
+	source := newChangeSource(src, path, opts)
+	defer source.Close()
+
+	w := &watcher{fsys: src, rootName: path, opts: opts}
+
+	return w.run(ctx, out, source)
+}
+
+type watcher struct {
+	fsys     fs.FS
+	rootName string
+	opts     *options
+	root     *entry
+	dir      *dirListing
+}
+
+func (w *watcher) run(ctx context.Context, out io.Writer, source changeSource) error {
+	err := w.load()
+
+	if err != nil {
+		return err
+	}
+
+	err = w.frame(out, w.dir)
+
+	if err != nil {
+		return err
+	}
+
+	for {
+		c, err := source.next(ctx)
+
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("error watch: %v", err)
+		}
+
+		err = w.step(out, c)
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (w *watcher) load() error {
+	root, dir, err := loadTree(w.fsys, w.rootName, w.opts, true)
+
+	if err != nil {
+		return err
+	}
+
+	w.root, w.dir = root, dir
+
+	return nil
+}
+
+// step re-reads what c names and draws a frame if anything changed.
+func (w *watcher) step(out io.Writer, c changes) error {
+	prev := w.dir
+
+	var err error
+	if c.all {
+		err = w.load()
+	} else {
+		err = w.refresh(c.dirs)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	diffOpts := *w.opts
+	diffOpts.hash = false
+	diffOpts.changedOnly = false
+
+	d := &treeDiff{oldFS: w.fsys, newFS: w.fsys, opts: &diffOpts}
+	merged, changed, err := d.merge(prev, w.dir)
+
+	if err != nil {
+		return fmt.Errorf("error diff: %v", err)
+	}
+
+	if !changed {
+		return nil
+	}
+
+	if w.opts.format == "" || w.opts.format == "text" {
+		_, err = io.WriteString(out, clearScreen)
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.frame(out, merged)
+}
+
+func (w *watcher) frame(out io.Writer, dir *dirListing) error {
+	r, err := newRenderer(out, w.opts)
+
+	if err != nil {
+		return fmt.Errorf("error renderer: %v", err)
+	}
+
+	err = r.begin(w.root)
+
+	if err != nil {
+		return fmt.Errorf("error render: %v", err)
+	}
+
+	err = walk(r, dir, w.opts)
+
+	if err != nil {
+		return fmt.Errorf("error walk: %v", err)
+	}
+
+	return r.end()
+}
+
+// refresh re-reads the listed directories, or their nearest loaded parent
+// for those below the depth limit, and keeps every other listing of the
+// previous frame. A .gitignore change is reported as a full re-read since
+// it changes the filters of the whole subtree.
+func (w *watcher) refresh(dirs []string) error {
+	stale := map[*dirListing]bool{}
+	for _, rel := range dirs {
+		stale[findListing(w.dir, rel)] = true
+	}
+
+	dir, err := refreshDir(w.dir, stale, w.opts)
+
+	if err != nil {
+		return err
+	}
+
+	err = prefetch(dir, w.opts, workerSem(w.opts))
+
+	if err != nil {
+		return fmt.Errorf("error walk: %v", err)
+	}
+
+	aggregate(w.root, dir, w.opts)
+	w.dir = dir
+
+	return nil
+}
+
+// findListing returns the deepest loaded listing on the way to rel.
+func findListing(dir *dirListing, rel string) *dirListing {
+	if rel == "." || rel == "" {
+		return dir
+	}
+
+	for _, name := range strings.Split(rel, "/") {
+		i := dir.indexOf(name)
+		if i < 0 || dir.children[i] == nil {
+			return dir
+		}
+		dir = dir.children[i]
+	}
+
+	return dir
+}
+
+// refreshDir copies dir with the stale listings below it read again. The
+// listings of the previous frame are not modified, so it can still be
+// compared with the new one.
+func refreshDir(dir *dirListing, stale map[*dirListing]bool, opts *options) (*dirListing, error) {
+	var fresh *dirListing
+
+	if stale[dir] {
+		var err error
+		fresh, err = loadDir(dir.fsys, dir.path, opts, dir.filter, dir.level, dir.chain)
+		if err != nil {
+			return nil, err
+		}
+		for i, e := range fresh.entries {
+			j := dir.indexOf(e.name)
+			if j >= 0 && fresh.canDescend(i, opts) && dir.entries[j].isDir {
+				fresh.children[i] = dir.children[j]
+			}
+		}
+	} else {
+		copied := *dir
+		copied.entries = append([]*entry(nil), dir.entries...)
+		copied.filters = append([]*entryFilter(nil), dir.filters...)
+		copied.children = append([]*dirListing(nil), dir.children...)
+		fresh = &copied
+	}
+
+	for i, child := range fresh.children {
+		if child == nil {
+			continue
+		}
+		sub, err := refreshDir(child, stale, opts)
+		if err != nil {
+			return nil, err
+		}
+		fresh.children[i] = sub
+	}
+
+	return fresh, nil
+}
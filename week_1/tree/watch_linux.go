@@ -0,0 +1,181 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF
+
+const (
+	// watchDebounce collects the burst of events a single save or checkout
+	// makes into one frame.
+	watchDebounce = 50 * time.Millisecond
+	// watchCheck is how often a blocked read looks at the context.
+	watchCheck = 100 * time.Millisecond
+)
+
+// inotifySource watches every directory below root, new directories are
+// added as they appear.
+type inotifySource struct {
+	fd   int
+	file *os.File
+	root string
+	dirs map[int32]string
+	buf  []byte
+}
+
+func newInotifySource(root string) (changeSource, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := &inotifySource{
+		fd:   fd,
+		file: os.NewFile(uintptr(fd), "inotify"),
+		root: root,
+		dirs: map[int32]string{},
+		buf:  make([]byte, 64*1024),
+	}
+
+	err = s.watchTree(".")
+
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *inotifySource) watchTree(rel string) error {
+	return fs.WalkDir(os.DirFS(s.root), rel, func(name string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+
+		wd, err := syscall.InotifyAddWatch(s.fd, filepath.Join(s.root, filepath.FromSlash(name)), inotifyMask)
+		if err == syscall.ENOENT {
+			return fs.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		s.dirs[int32(wd)] = name
+
+		return nil
+	})
+}
+
+func (s *inotifySource) next(ctx context.Context) (changes, error) {
+	c := changes{}
+	dirs := map[string]bool{}
+	var flush time.Time
+
+	for {
+		deadline := time.Now().Add(watchCheck)
+		if !flush.IsZero() {
+			deadline = flush
+		}
+
+		err := s.file.SetReadDeadline(deadline)
+		if err != nil {
+			return c, err
+		}
+
+		n, err := s.file.Read(s.buf)
+
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if !flush.IsZero() && !time.Now().Before(flush) {
+				break
+			}
+			if ctx.Err() != nil {
+				return c, ctx.Err()
+			}
+			continue
+		}
+
+		if err != nil {
+			return c, err
+		}
+
+		err = s.parse(s.buf[:n], dirs, &c)
+		if err != nil {
+			return c, err
+		}
+
+		if flush.IsZero() {
+			flush = time.Now().Add(watchDebounce)
+		}
+	}
+
+	for dir := range dirs {
+		c.dirs = append(c.dirs, dir)
+	}
+	sort.Strings(c.dirs)
+
+	return c, nil
+}
+
+func (s *inotifySource) parse(buf []byte, dirs map[string]bool, c *changes) error {
+	for off := 0; off+syscall.SizeofInotifyEvent <= len(buf); {
+		ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+		start := off + syscall.SizeofInotifyEvent
+		off = start + int(ev.Len)
+		name := strings.TrimRight(string(buf[start:off]), "\x00")
+
+		if ev.Mask&syscall.IN_Q_OVERFLOW != 0 {
+			c.all = true
+			continue
+		}
+
+		dir, ok := s.dirs[ev.Wd]
+		if !ok {
+			continue
+		}
+
+		if ev.Mask&syscall.IN_IGNORED != 0 {
+			delete(s.dirs, ev.Wd)
+			continue
+		}
+
+		if name == ".gitignore" {
+			c.all = true
+		}
+
+		dirs[dir] = true
+
+		if name != "" && ev.Mask&syscall.IN_ISDIR != 0 && ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+			err := s.watchTree(path.Join(dir, name))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *inotifySource) Close() error {
+	return s.file.Close()
+}
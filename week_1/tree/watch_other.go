@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func newInotifySource(root string) (changeSource, error) {
+	return nil, errors.New("inotify is not available")
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWatchFrames(t *testing.T) {
+	root := makeTree(t, map[string]string{
+		"main.go":      "package main",
+		"pkg/util.go":  "package pkg",
+		"docs/a/b.txt": "b",
+	})
+
+	_, opts, err := parseArgs([]string{root, "-f", "--du"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := &watcher{fsys: newDirFS(root), rootName: root, opts: opts}
+	if err := w.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := new(bytes.Buffer)
+	if err := w.frame(out, w.dir); err != nil {
+		t.Fatal(err)
+	}
+	if expected := runTree(t, root, "-f", "--du"); out.String() != expected {
+		t.Errorf("first frame differs from a one-shot run\nGot:\n%v\nExpected:\n%v", out.String(), expected)
+	}
+
+	write := func(name string, content string) {
+		full := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(full, testModTime, testModTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("pkg/util.go", "package pkg\n\nfunc Util() {}\n")
+	write("pkg/new/new.go", "package new")
+	if err := os.Remove(filepath.Join(root, "main.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	out.Reset()
+	if err := w.step(out, changes{dirs: []string{".", "pkg"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := clearScreen + `├───docs (1b)
+│	└───a (1b)
+│		└───b.txt (1b)
+├───[-] main.go (12b)
+└───[~] pkg (39b)
+	├───[+] new (11b)
+	│	└───[+] new.go (11b)
+	└───[~] util.go (28b) [size]
+`
+	if out.String() != expected {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", out.String(), expected)
+	}
+
+	out.Reset()
+	if err := w.step(out, changes{dirs: []string{"docs/a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("frame drawn without changes:\n%v", out.String())
+	}
+
+	out.Reset()
+	if err := w.frame(out, w.dir); err != nil {
+		t.Fatal(err)
+	}
+	if expected := runTree(t, root, "-f", "--du"); out.String() != expected {
+		t.Errorf("refreshed tree differs from a one-shot run\nGot:\n%v\nExpected:\n%v", out.String(), expected)
+	}
+}
+
+func TestWatchInotify(t *testing.T) {
+	root := makeTree(t, map[string]string{
+		"pkg/util.go": "package pkg",
+	})
+
+	source, err := newInotifySource(root)
+	if err != nil {
+		t.Skipf("inotify: %v", err)
+	}
+	defer source.Close()
+
+	if err := os.MkdirAll(filepath.Join(root, "pkg", "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := source.next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (changes{dirs: []string{"pkg"}}); !reflect.DeepEqual(c, expected) {
+		t.Errorf("got %+v, expected %+v", c, expected)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "pkg", "sub", "x.go"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = source.next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (changes{dirs: []string{"pkg/sub"}}); !reflect.DeepEqual(c, expected) {
+		t.Errorf("got %+v, expected %+v", c, expected)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// xmlRenderer follows the layout of tree -X: nested directory and file
+// elements inside <tree>, followed by a <report> with the totals.
+type xmlRenderer struct {
+	out   io.Writer
+	opts  *options
+	depth int
+	dirs  int
+	files int
+}
+
+func (x *xmlRenderer) begin(root *entry) error {
+	_, err := fmt.Fprintf(x.out, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<tree>\n")
+	if err != nil {
+		return err
+	}
+	x.depth = 1
+	return x.open(root)
+}
+
+func (x *xmlRenderer) file(e *entry, isLast bool) error {
+	x.files++
+	_, err := fmt.Fprintf(x.out, "%s<%s%s></%s>\n", x.indent(), e.kind(), x.attrs(e), e.kind())
+	return err
+}
+
+func (x *xmlRenderer) openDir(e *entry, isLast bool) error {
+	x.dirs++
+	if e.summary != nil {
+		x.dirs += e.summary.dirs
+		x.files += e.summary.files
+	}
+	return x.open(e)
+}
+
+func (x *xmlRenderer) closeDir(e *entry) error {
+	x.depth--
+	_, err := fmt.Fprintf(x.out, "%s</directory>\n", x.indent())
+	return err
+}
+
+func (x *xmlRenderer) end() error {
+	err := x.closeDir(nil)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(x.out, "  <report>\n    <directories>%d</directories>\n    <files>%d</files>\n  </report>\n</tree>\n", x.dirs, x.files)
+	return err
+}
+
+func (x *xmlRenderer) open(e *entry) error {
+	_, err := fmt.Fprintf(x.out, "%s<directory%s>\n", x.indent(), x.attrs(e))
+	x.depth++
+	return err
+}
+
+func (x *xmlRenderer) indent() string {
+	return strings.Repeat("  ", x.depth)
+}
+
+func (x *xmlRenderer) attrs(e *entry) string {
+	var sb strings.Builder
+
+	attr := func(name string, value string) {
+		sb.WriteString(" " + name + "=\"")
+		xml.EscapeText(&sb, []byte(value))
+		sb.WriteString("\"")
+	}
+
+	attr("name", e.name)
+	attr("size", fmt.Sprint(e.size))
+	attr("mode", e.mode.String())
+	attr("mtime", e.modTime.Format(time.RFC3339))
+	if e.isLink {
+		attr("target", e.linkTarget)
+	}
+	if x.opts.showOwner {
+		attr("user", e.owner())
+	}
+	if x.opts.showGroup {
+		attr("group", e.group())
+	}
+	if e.recursive {
+		attr("recursive", "true")
+	}
+	if e.status != "" {
+		attr("status", e.status)
+	}
+	if len(e.changes) > 0 {
+		attr("changes", strings.Join(e.changes, ","))
+	}
+	if e.summary != nil {
+		attr("dirs", fmt.Sprint(e.summary.dirs))
+		attr("files", fmt.Sprint(e.summary.files))
+		attr("total", fmt.Sprint(e.summary.size))
+	}
+	if e.stats != nil {
+		attr("lines", fmt.Sprint(e.stats.lines))
+		if !e.isDir {
+			attr("language", e.stats.language())
+		}
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Md5Cache and Crc32Cache remember the results of DataSignerMd5 and
+// DataSignerCrc32 for the hashing stages, nil means no caching. A cached
+// result does not take the limiter quota.
+var (
+	Md5Cache   *Cache
+	Crc32Cache *Cache
+)
+
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+	Size   int
+}
+
+type cacheKey struct {
+	Algorithm string `json:"algorithm"`
+	Salt      string `json:"salt"`
+	Data      string `json:"data"`
+}
+
+type cacheEntry struct {
+	cacheKey
+	Value string `json:"value"`
+}
+
+// Cache is an LRU of signer results keyed by algorithm, DataSignerSalt and
+// input. With a file every new result is appended to it, and the file is
+// read back into the LRU when the cache is opened again.
+type Cache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	items    map[cacheKey]*list.Element
+	stats    CacheStats
+	file     *os.File
+	err      error
+}
+
+// NewCache keeps at most capacity results in memory, 0 means no limit.
+func NewCache(capacity int) *Cache {
+	return &Cache{capacity: capacity, order: list.New(), items: map[cacheKey]*list.Element{}}
+}
+
+// OpenCache is NewCache backed by the file at path, created if missing.
+// Only the last capacity results of the file are loaded.
+func OpenCache(path string, capacity int) (*Cache, error) {
+	c := NewCache(capacity)
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		entry := cacheEntry{}
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("cache %s:%d: %v", path, line, err)
+		}
+		c.put(entry.cacheKey, entry.Value)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("cache %s: %v", path, err)
+	}
+
+	c.file = file
+	return c, nil
+}
+
+func (c *Cache) get(key cacheKey) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		return "", false
+	}
+	c.stats.Hits++
+	c.order.MoveToFront(element)
+	return element.Value.(*cacheEntry).Value, true
+}
+
+func (c *Cache) add(key cacheKey, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.put(key, value)
+
+	if c.file == nil || c.err != nil {
+		return
+	}
+	line, err := json.Marshal(cacheEntry{cacheKey: key, Value: value})
+	if err == nil {
+		_, err = c.file.Write(append(line, '\n'))
+	}
+	c.err = err
+}
+
+func (c *Cache) put(key cacheKey, value string) {
+	if element, ok := c.items[key]; ok {
+		element.Value.(*cacheEntry).Value = value
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&cacheEntry{cacheKey: key, Value: value})
+	if c.capacity > 0 && c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).cacheKey)
+	}
+}
+
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Size = c.order.Len()
+	return stats
+}
+
+// Close closes the file, it returns the first error of writing to it.
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		return c.err
+	}
+	err := c.file.Close()
+	c.file = nil
+	if c.err != nil {
+		return c.err
+	}
+	return err
+}
+
+// Memoize wraps signer so that its results are looked up in cache first.
+// Two calls with the same input that miss at once both call signer.
+func Memoize(algorithm string, signer func(string) string, cache *Cache) func(string) string {
+	if cache == nil {
+		return signer
+	}
+	return func(data string) string {
+		key := cacheKey{Algorithm: algorithm, Salt: DataSignerSalt, Data: data}
+		if value, ok := cache.get(key); ok {
+			return value
+		}
+		value := signer(data)
+		cache.add(key, value)
+		return value
+	}
+}
+
+// MemoizeSigner is Memoize for a Signer, only results of calls that
+// succeed are kept.
+func MemoizeSigner(algorithm string, signer Signer, cache *Cache) Signer {
+	if cache == nil {
+		return signer
+	}
+	return func(ctx context.Context, data string) (string, error) {
+		key := cacheKey{Algorithm: algorithm, Salt: DataSignerSalt, Data: data}
+		if value, ok := cache.get(key); ok {
+			return value, nil
+		}
+		value, err := signer(ctx, data)
+		if err == nil {
+			cache.add(key, value)
+		}
+		return value, err
+	}
+}
+
+// md5Signer and crc32Signer are the signers with the limiters and caches
+// the hashing stages use.
+func md5Signer() func(string) string {
+	return Memoize("md5", Limit(&DataSignerMd5, Md5Limiter), Md5Cache)
+}
+
+func crc32Signer() func(string) string {
+	return Memoize("crc32", Limit(&DataSignerCrc32, Crc32Limiter), Crc32Cache)
+}
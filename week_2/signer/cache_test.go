@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func countingSigner(calls *int32) func(string) string {
+	return func(data string) string {
+		atomic.AddInt32(calls, 1)
+		return "<" + data + DataSignerSalt + ">"
+	}
+}
+
+func TestCacheLRU(t *testing.T) {
+	var calls int32
+	cache := NewCache(2)
+	signer := Memoize("test", countingSigner(&calls), cache)
+
+	for _, data := range []string{"a", "b", "a", "c", "b", "a"} {
+		if result := signer(data); result != "<"+data+">" {
+			t.Errorf("unexpected result %s", result)
+		}
+	}
+
+	// b is evicted by c, then a by b
+	if calls != 5 {
+		t.Errorf("signer called %d times", calls)
+	}
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 5 || stats.Size != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCacheFile(t *testing.T) {
+	salt := DataSignerSalt
+	defer func() {
+		DataSignerSalt = salt
+	}()
+
+	var calls int32
+	path := filepath.Join(t.TempDir(), "signer.cache")
+
+	cache, err := OpenCache(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Memoize("test", countingSigner(&calls), cache)("a")
+	Memoize("test", countingSigner(&calls), cache)("b")
+	Memoize("other", countingSigner(&calls), cache)("a")
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err = OpenCache(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	signer := Memoize("test", countingSigner(&calls), cache)
+	if signer("a") != "<a>" || signer("b") != "<b>" || calls != 3 {
+		t.Errorf("results are not read from the file, %d calls", calls)
+	}
+
+	DataSignerSalt = "salt"
+	if signer("a") != "<asalt>" || calls != 4 {
+		t.Errorf("salt is not a part of the key")
+	}
+	if stats := cache.Stats(); stats.Hits != 2 || stats.Misses != 1 || stats.Size != 4 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	os.WriteFile(path, []byte("{\"data\": \"a\"}\nnot json\n"), 0644)
+	if _, err := OpenCache(path, 0); err == nil {
+		t.Errorf("broken file is not reported")
+	}
+}
+
+func TestHashCaches(t *testing.T) {
+	fastSigners(t)
+	md5Cache, crc32Cache := Md5Cache, Crc32Cache
+	defer func() {
+		Md5Cache, Crc32Cache = md5Cache, crc32Cache
+	}()
+	Md5Cache, Crc32Cache = NewCache(100), NewCache(100)
+
+	signer := Pipe(Pipe(Pipe(Map(itoa), SingleHashStage), MultiHashStage), CombineResultsStage)
+	expected := "29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542"
+
+	for i := 0; i < 2; i++ {
+		result, err := Run(context.Background(), signer, []int{0, 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result) != 1 || result[0] != expected {
+			t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+		}
+	}
+
+	// 2 md5 and 16 crc32 calls per run, the second run is all hits
+	if stats := Md5Cache.Stats(); stats.Hits != 2 || stats.Misses != 2 {
+		t.Errorf("unexpected md5 stats %+v", stats)
+	}
+	if stats := Crc32Cache.Stats(); stats.Hits != 16 || stats.Misses != 16 {
+		t.Errorf("unexpected crc32 stats %+v", stats)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// CheckpointSyncEvery and CheckpointSyncInterval say how often a
+// checkpoint is synced to disk: after that many records or that long after
+// a record is written, whichever comes first.
+var (
+	CheckpointSyncEvery    = 100
+	CheckpointSyncInterval = time.Second
+)
+
+type checkpointKey struct {
+	Stage string `json:"stage"`
+	Salt  string `json:"salt"`
+	Input string `json:"input"`
+}
+
+type checkpointRecord struct {
+	checkpointKey
+	Output string `json:"output"`
+}
+
+// CheckpointStats tells how much work a checkpoint saved: Loaded records
+// were read from the file, Skipped items were found there instead of being
+// hashed, Written records were added and the file was Synced to disk that
+// many times.
+type CheckpointStats struct {
+	Loaded  int
+	Skipped int64
+	Written int64
+	Synced  int64
+}
+
+// Checkpoint is an append-only file of the items that finished a stage,
+// with the stage output, e.g. the SingleHash of every item that got
+// through SingleHash. A resumed run skips those, so after a crash only the
+// unfinished work is redone. Records are keyed by the stage name,
+// DataSignerSalt and input, a run with a changed recipe needs a new file.
+//
+// A record survives a crash of the process as soon as it is written. It
+// survives a power loss or an OS crash once the file is synced, see
+// CheckpointSyncEvery; Close syncs the rest.
+type Checkpoint struct {
+	mu       sync.Mutex
+	file     *os.File
+	done     map[checkpointKey]string
+	stats    CheckpointStats
+	err      error
+	unsynced int
+	timer    *time.Timer
+	closed   bool
+}
+
+// OpenCheckpoint starts a checkpoint at path. With resume it keeps the
+// records already in the file, otherwise the file is truncated. A last
+// record cut short by a crash is dropped.
+func OpenCheckpoint(path string, resume bool) (*Checkpoint, error) {
+	flags := os.O_RDWR | os.O_CREATE | os.O_APPEND
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Checkpoint{file: file, done: map[checkpointKey]string{}}
+	err = c.load(path)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Checkpoint) load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	complete := bytes.LastIndexByte(data, '\n') + 1
+	lines := bytes.Split(data[:complete], []byte("\n"))
+	for i, line := range lines[:len(lines)-1] {
+		record := checkpointRecord{}
+		err := json.Unmarshal(line, &record)
+		if err != nil {
+			return fmt.Errorf("checkpoint %s:%d: %v", path, i+1, err)
+		}
+		c.done[record.checkpointKey] = record.Output
+		c.stats.Loaded++
+	}
+
+	if complete < len(data) {
+		return c.file.Truncate(int64(complete))
+	}
+	return nil
+}
+
+func (c *Checkpoint) get(key checkpointKey) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	output, ok := c.done[key]
+	if ok {
+		c.stats.Skipped++
+	}
+	return output, ok
+}
+
+func (c *Checkpoint) add(key checkpointKey, output string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.done[key] = output
+	if c.err != nil {
+		return
+	}
+
+	line, err := json.Marshal(checkpointRecord{checkpointKey: key, Output: output})
+	if err == nil {
+		_, err = c.file.Write(append(line, '\n'))
+	}
+	c.err = err
+	if err != nil {
+		return
+	}
+
+	c.stats.Written++
+	c.unsynced++
+	switch {
+	case c.unsynced >= CheckpointSyncEvery:
+		c.sync()
+	case c.timer == nil:
+		c.timer = time.AfterFunc(CheckpointSyncInterval, func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if !c.closed {
+				c.sync()
+			}
+		})
+	}
+}
+
+// sync flushes the records written since the last sync, c.mu is held.
+func (c *Checkpoint) sync() {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if c.unsynced == 0 || c.err != nil {
+		return
+	}
+	c.err = c.file.Sync()
+	c.unsynced = 0
+	c.stats.Synced++
+}
+
+// Wrap makes f skip inputs the checkpoint has for stage and record the
+// rest. A nil checkpoint returns f as is.
+func (c *Checkpoint) Wrap(stage string, f func(string) string) func(string) string {
+	if c == nil {
+		return f
+	}
+	return func(input string) string {
+		key := checkpointKey{Stage: stage, Salt: DataSignerSalt, Input: input}
+		if output, ok := c.get(key); ok {
+			return output
+		}
+		output := f(input)
+		c.add(key, output)
+		return output
+	}
+}
+
+func (c *Checkpoint) Stats() CheckpointStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Close syncs and closes the file, it returns the first error of writing
+// to it.
+func (c *Checkpoint) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sync()
+	c.closed = true
+	err := c.file.Close()
+	if c.err != nil {
+		return c.err
+	}
+	return err
+}
+
+// SingleHashCheckpoint is SingleHashContext that skips the items
+// checkpoint has and records the others.
+func SingleHashCheckpoint(checkpoint *Checkpoint) ctxJob {
+	return untypedHash("SingleHash", Each(checkpoint.Wrap("SingleHash", singleHash)))
+}
+
+// MultiHashCheckpoint is MultiHashContext that skips the items checkpoint
+// has and records the others.
+func MultiHashCheckpoint(checkpoint *Checkpoint) ctxJob {
+	return untypedHash("MultiHash", Each(checkpoint.Wrap("MultiHash", multiHash)))
+}
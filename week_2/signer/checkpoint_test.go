@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countCrc32 counts DataSignerCrc32 calls, it goes after fastSigners.
+func countCrc32(t *testing.T) *int32 {
+	calls := new(int32)
+	crc32Signer := DataSignerCrc32
+	DataSignerCrc32 = func(data string) string {
+		atomic.AddInt32(calls, 1)
+		return crc32Signer(data)
+	}
+	return calls
+}
+
+func runCheckpoint(t *testing.T, path string, resume bool, items ...interface{}) (string, CheckpointStats) {
+	t.Helper()
+	checkpoint, err := OpenCheckpoint(path, resume)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := []string{}
+	err = ExecutePipelineContext(context.Background(),
+		source(items...),
+		SingleHashCheckpoint(checkpoint),
+		MultiHashCheckpoint(checkpoint),
+		CombineResultsContext,
+		collect(&result),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := checkpoint.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return result[0], checkpoint.Stats()
+}
+
+func TestCheckpointResume(t *testing.T) {
+	fastSigners(t)
+	calls := countCrc32(t)
+	path := filepath.Join(t.TempDir(), "signer.checkpoint")
+
+	_, stats := runCheckpoint(t, path, false, 0, 1)
+	if stats.Loaded != 0 || stats.Skipped != 0 || stats.Written != 4 || *calls != 16 {
+		t.Errorf("unexpected stats %+v, %d crc32 calls", stats, *calls)
+	}
+
+	// a crash in the middle of writing a record
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	file.WriteString(`{"stage":"SingleHash","salt":"","inp`)
+	file.Close()
+
+	*calls = 0
+	result, stats := runCheckpoint(t, path, true, 0, 1, 2)
+	if stats.Loaded != 4 || stats.Skipped != 4 || stats.Written != 2 || *calls != 8 {
+		t.Errorf("unexpected stats %+v, %d crc32 calls", stats, *calls)
+	}
+
+	expected, _ := runCheckpoint(t, filepath.Join(t.TempDir(), "fresh"), false, 0, 1, 2)
+	if result != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+
+	*calls = 0
+	if _, stats = runCheckpoint(t, path, true, 2, 1, 0); stats.Loaded != 6 || stats.Written != 0 || *calls != 0 {
+		t.Errorf("unexpected stats %+v, %d crc32 calls", stats, *calls)
+	}
+	if _, stats = runCheckpoint(t, path, false, 0); stats.Loaded != 0 || stats.Written != 2 {
+		t.Errorf("checkpoint is not reset: %+v", stats)
+	}
+}
+
+func TestCheckpointBroken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signer.checkpoint")
+	os.WriteFile(path, []byte("not json\n{}\n"), 0644)
+	if _, err := OpenCheckpoint(path, true); err == nil {
+		t.Errorf("broken record is not reported")
+	}
+}
+
+func TestCheckpointSync(t *testing.T) {
+	every, interval := CheckpointSyncEvery, CheckpointSyncInterval
+	defer func() {
+		CheckpointSyncEvery, CheckpointSyncInterval = every, interval
+	}()
+	CheckpointSyncEvery, CheckpointSyncInterval = 3, 20*time.Millisecond
+
+	checkpoint, err := OpenCheckpoint(filepath.Join(t.TempDir(), "signer.checkpoint"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := checkpoint.Wrap("Hash", func(input string) string {
+		return input + "!"
+	})
+
+	for _, input := range []string{"0", "1", "2", "3"} {
+		hash(input)
+	}
+	if stats := checkpoint.Stats(); stats.Written != 4 || stats.Synced != 1 {
+		t.Errorf("every 3 records are not synced: %+v", stats)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if stats := checkpoint.Stats(); stats.Synced != 2 {
+		t.Errorf("the last record is not synced in time: %+v", stats)
+	}
+
+	hash("4")
+	if err := checkpoint.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := checkpoint.Stats(); stats.Synced != 3 {
+		t.Errorf("close does not sync: %+v", stats)
+	}
+}
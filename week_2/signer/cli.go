@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+type cliOptions struct {
+	recipe     string
+	stages     string
+	salt       string
+	workers    int
+	workersSet bool
+	cache      string
+	checkpoint string
+	resume     bool
+	progress   time.Duration
+	files      []string
+}
+
+func parseCLIArgs(args []string, stderr io.Writer) (*cliOptions, error) {
+	opts := &cliOptions{}
+	flags := flag.NewFlagSet("signer", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: signer [options] [file ...], reads stdin without files or for -")
+		flags.PrintDefaults()
+	}
+
+	flags.StringVar(&opts.recipe, "recipe", "", "YAML or JSON recipe file, the default is SingleHash, MultiHash and CombineResults")
+	flags.StringVar(&opts.stages, "stages", "", "comma separated recipe stages to run, all by default")
+	flags.StringVar(&opts.salt, "salt", DataSignerSalt, "DataSignerSalt")
+	flags.IntVar(&opts.workers, "workers", 100, "items hashed at once by each stage, for the recipe stages without workers unless set")
+	flags.StringVar(&opts.cache, "cache", "", "file to keep signer results in between runs")
+	flags.StringVar(&opts.checkpoint, "checkpoint", "", "file to record finished items in")
+	flags.BoolVar(&opts.resume, "resume", false, "skip the items the -checkpoint file has from an interrupted run")
+	flags.DurationVar(&opts.progress, "progress", time.Second, "how often to report progress on stderr, 0 disables it")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "workers" {
+			opts.workersSet = true
+		}
+	})
+	if opts.workers < 1 {
+		return nil, fmt.Errorf("bad workers %d", opts.workers)
+	}
+	if opts.resume && opts.checkpoint == "" {
+		return nil, fmt.Errorf("-resume needs -checkpoint")
+	}
+	opts.files = flags.Args()
+	return opts, nil
+}
+
+// selectStages returns the stages of recipe named in names, in the order
+// of names. Hash stages emit items in input order, so that per item
+// results line up with the input lines. They get workers if the recipe
+// leaves their Workers at 0 or if override is set, as it is by an explicit
+// -workers flag.
+func selectStages(recipe Recipe, names string, workers int, override bool) (Recipe, error) {
+	selected := Recipe{}
+	if names == "" {
+		selected.Stages = append(selected.Stages, recipe.Stages...)
+	}
+
+	for _, name := range strings.Split(names, ",") {
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, stage := range recipe.Stages {
+			if stage.Name == name {
+				selected.Stages = append(selected.Stages, stage)
+				found = true
+				break
+			}
+		}
+		if !found {
+			available := []string{}
+			for _, stage := range recipe.Stages {
+				available = append(available, stage.Name)
+			}
+			return selected, fmt.Errorf("unknown stage %q, the recipe has %s", name, strings.Join(available, ", "))
+		}
+	}
+
+	for i := range selected.Stages {
+		stage := &selected.Stages[i]
+		if stage.Combine {
+			continue
+		}
+		if override || stage.Workers == 0 {
+			stage.Workers = workers
+		}
+		stage.Ordered = true
+	}
+	return selected, nil
+}
+
+// run closes the checkpoint and the cache once the pipeline is done, which
+// syncs the last checkpoint records, and fails if that or an earlier write
+// of either file failed.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) (err error) {
+	opts, err := parseCLIArgs(args, stderr)
+	if err != nil {
+		return err
+	}
+
+	recipe := DefaultRecipe
+	if opts.recipe != "" {
+		recipe, err = LoadRecipe(opts.recipe)
+		if err != nil {
+			return err
+		}
+	}
+	recipe, err = selectStages(recipe, opts.stages, opts.workers, opts.workersSet)
+	if err != nil {
+		return err
+	}
+
+	var checkpoint *Checkpoint
+	if opts.checkpoint != "" {
+		checkpoint, err = OpenCheckpoint(opts.checkpoint, opts.resume)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := checkpoint.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+	}
+	stages, err := recipe.CompileCheckpoint(checkpoint)
+	if err != nil {
+		return err
+	}
+
+	// the signers are configured through globals, which are put back for
+	// the sake of tests
+	salt, md5Cache, crc32Cache := DataSignerSalt, Md5Cache, Crc32Cache
+	defer func() {
+		DataSignerSalt, Md5Cache, Crc32Cache = salt, md5Cache, crc32Cache
+	}()
+
+	DataSignerSalt = opts.salt
+	if opts.cache != "" {
+		var cache *Cache
+		cache, err = OpenCache(opts.cache, 0)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := cache.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		Md5Cache, Crc32Cache = cache, cache
+	}
+
+	out := bufio.NewWriter(stdout)
+	stages = append([]StageConfig{{Name: "read", Job: readLines(opts.files, stdin)}}, stages...)
+	stages = append(stages, StageConfig{Name: "print", Job: printLines(out)})
+
+	metrics := NewMetrics()
+	start := time.Now()
+	stopProgress := reportProgress(stderr, metrics, start, opts.progress)
+	err = ExecutePipelineObserved(ctx, metrics, stages...)
+	stopProgress()
+
+	if flushErr := out.Flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+// readLines sends the lines of files, or of stdin if there are none.
+func readLines(files []string, stdin io.Reader) ctxJob {
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	return func(ctx context.Context, in, out chan interface{}) error {
+		for _, path := range files {
+			err := readFile(ctx, path, stdin, out)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func readFile(ctx context.Context, path string, stdin io.Reader, out chan interface{}) error {
+	var r io.Reader = stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		err := send(ctx, out, interface{}(scanner.Text()))
+		if err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read %s: %v", path, err)
+	}
+	return nil
+}
+
+func printLines(out io.Writer) ctxJob {
+	return func(ctx context.Context, in, _ chan interface{}) error {
+		for {
+			data, ok, err := receive(ctx, in)
+			if err != nil || !ok {
+				return err
+			}
+			_, err = fmt.Fprintln(out, data)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// reportProgress prints how many items every stage has emitted each
+// interval and once more when stopped.
+func reportProgress(out io.Writer, metrics *Metrics, start time.Time, interval time.Duration) func() {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				fmt.Fprintln(out, progressLine(metrics.Snapshot(), time.Since(start)))
+				return
+			case <-ticker.C:
+				fmt.Fprintln(out, progressLine(metrics.Snapshot(), time.Since(start)))
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+func progressLine(snapshot MetricsSnapshot, elapsed time.Duration) string {
+	counts := []string{}
+	for _, s := range snapshot.Stages {
+		if s.Name == "print" {
+			continue
+		}
+		counts = append(counts, fmt.Sprintf("%s %d", s.Name, s.Out))
+	}
+	return fmt.Sprintf("%s: %s", elapsed.Round(time.Millisecond), strings.Join(counts, ", "))
+}
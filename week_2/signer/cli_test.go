@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runCLI(t *testing.T, stdin string, args ...string) (string, string, error) {
+	t.Helper()
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	err := run(context.Background(), args, strings.NewReader(stdin), stdout, stderr)
+	return stdout.String(), stderr.String(), err
+}
+
+func TestCLI(t *testing.T) {
+	fastSigners(t)
+
+	out, _, err := runCLI(t, "0\n1\n", "-progress", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542\n"
+	if out != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", out, expected)
+	}
+
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first.txt"), filepath.Join(dir, "second.txt")
+	os.WriteFile(first, []byte("0\n1\n2\n3\n"), 0644)
+	os.WriteFile(second, []byte("4\n5\n"), 0644)
+
+	out, _, err = runCLI(t, "6\n", "-progress=0", "-stages", "SingleHash", "-workers", "2", first, "-", second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{}
+	for _, data := range []string{"0", "1", "2", "3", "6", "4", "5"} {
+		lines = append(lines, singleHash(data))
+	}
+	if expected := strings.Join(lines, "\n") + "\n"; out != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", out, expected)
+	}
+}
+
+func TestCLISaltAndProgress(t *testing.T) {
+	fastSigners(t)
+
+	DataSignerSalt = "salt"
+	expected := multiHash(singleHash("0")) + "\n"
+	DataSignerSalt = ""
+
+	out, progress, err := runCLI(t, "0\n", "-salt", "salt", "-progress", "1ms", "-stages", "SingleHash,MultiHash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", out, expected)
+	}
+	if DataSignerSalt != "" {
+		t.Errorf("salt is left %q", DataSignerSalt)
+	}
+
+	lines := strings.Split(strings.TrimSpace(progress), "\n")
+	if last := lines[len(lines)-1]; !strings.HasSuffix(last, ": read 1, SingleHash 1, MultiHash 1") {
+		t.Errorf("unexpected progress %q", progress)
+	}
+}
+
+func TestCLIErrors(t *testing.T) {
+	fastSigners(t)
+
+	cases := map[string][]string{
+		`unknown stage "Sha", the recipe has SingleHash, MultiHash, CombineResults`: {"-stages", "SingleHash,Sha"},
+		"open missing.txt: no such file or directory":                               {"-progress", "0", "missing.txt"},
+		"bad workers 0":     {"-workers", "0"},
+		"recipe: no stages": {"-stages", ","},
+	}
+	for expected, args := range cases {
+		_, _, err := runCLI(t, "", args...)
+		if err == nil || err.Error() != expected {
+			t.Errorf("%v: expected %q, got %v", args, expected, err)
+		}
+	}
+
+	_, usage, err := runCLI(t, "", "-no-such-flag")
+	if err == nil || !strings.Contains(usage, "usage: signer") {
+		t.Errorf("no usage for a bad flag: %v", err)
+	}
+}
+
+func TestCLIWorkers(t *testing.T) {
+	recipe := Recipe{Stages: []RecipeStage{
+		{Name: "fixed", Workers: 3},
+		{Name: "open"},
+		{Name: "combine", Combine: true},
+	}}
+
+	for _, c := range []struct {
+		args    []string
+		workers []int
+	}{
+		{nil, []int{3, 100, 0}},
+		{[]string{"-workers", "7"}, []int{7, 7, 0}},
+	} {
+		opts, err := parseCLIArgs(c.args, new(bytes.Buffer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		selected, err := selectStages(recipe, "", opts.workers, opts.workersSet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, stage := range selected.Stages {
+			if stage.Workers != c.workers[i] || stage.Ordered == stage.Combine {
+				t.Errorf("%v: unexpected stage %+v", c.args, stage)
+			}
+		}
+	}
+	if recipe.Stages[1].Workers != 0 {
+		t.Errorf("recipe changed: %+v", recipe.Stages[1])
+	}
+}
+
+func TestCLICheckpoint(t *testing.T) {
+	fastSigners(t)
+	calls := countCrc32(t)
+	path := filepath.Join(t.TempDir(), "signer.checkpoint")
+
+	first, _, err := runCLI(t, "0\n1\n", "-progress=0", "-checkpoint", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	*calls = 0
+	second, _, err := runCLI(t, "0\n1\n", "-progress=0", "-checkpoint", path, "-resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second || *calls != 0 {
+		t.Errorf("resumed run differs or hashes again: %q, %q, %d crc32 calls", first, second, *calls)
+	}
+
+	if _, _, err := runCLI(t, "", "-resume"); err == nil || err.Error() != "-resume needs -checkpoint" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ctxJob is an untyped pipeline stage that stops once ctx is cancelled and
+// reports a failure as an error instead of panicking.
+type ctxJob = Stage[interface{}, interface{}]
+
+// ExecutePipelineContext runs workers like ExecutePipeline. The first error
+// returned by a worker cancels ctx for all the others and is returned once
+// every worker has finished. A finished worker's input is drained, so the
+// stages before it never block on a send.
+func ExecutePipelineContext(ctx context.Context, workers ...ctxJob) error {
+	stages := make([]StageConfig, len(workers))
+	for i, worker := range workers {
+		stages[i] = StageConfig{Job: worker}
+	}
+	return ExecutePipelineConfig(ctx, stages...)
+}
+
+// StageConfig is a stage of ExecutePipelineConfig. Buffer is the capacity
+// of the channel to the next stage: a producer runs at most Buffer items
+// ahead of its consumer and then blocks until the consumer catches up.
+// Name is used in metrics, the default is the stage number.
+type StageConfig struct {
+	Job    ctxJob
+	Buffer int
+	Name   string
+}
+
+func (s StageConfig) name(i int) string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return strconv.Itoa(i)
+}
+
+// ExecutePipelineConfig is ExecutePipelineContext with buffered channels
+// between the stages. The number of items a stage works on at once is up
+// to the stage, see Pool, SingleHashPool and MultiHashPool.
+func ExecutePipelineConfig(ctx context.Context, stages ...StageConfig) error {
+	return ExecutePipelineObserved(ctx, nil, stages...)
+}
+
+// ExecutePipelineObserved is ExecutePipelineConfig that reports the stages
+// and the items passed between them to observer, see Metrics. Each
+// observed buffer holds at least one item.
+func ExecutePipelineObserved(ctx context.Context, observer Observer, stages ...StageConfig) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	p := &pipeline{
+		ctx:      ctx,
+		cancel:   cancel,
+		wg:       &sync.WaitGroup{},
+		errs:     &firstError{},
+		observer: observer,
+	}
+	in := make(chan interface{})
+	close(in)
+
+	for i, stage := range stages {
+		name := stage.name(i)
+		buffer := stage.Buffer
+		if observer != nil {
+			if i > 0 {
+				in = p.startLink(in, stages[i-1].Buffer, stages[i-1].name(i-1), name)
+			}
+			buffer = 0
+		}
+		in = p.startStage(stage.Job, name, buffer, in)
+	}
+
+	p.wg.Wait()
+
+	if p.errs.err != nil {
+		return p.errs.err
+	}
+
+	return ctx.Err()
+}
+
+type pipeline struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       *sync.WaitGroup
+	errs     *firstError
+	observer Observer
+}
+
+func (p *pipeline) startStage(worker ctxJob, name string, buffer int, in chan interface{}) chan interface{} {
+	out := make(chan interface{}, buffer)
+	if p.observer != nil {
+		p.observer.StageStarted(name)
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer drain(in)
+		defer close(out)
+		err := runStage(p.ctx, worker, in, out)
+		if err != nil {
+			p.errs.set(err)
+			p.cancel()
+		}
+		if p.observer != nil {
+			p.observer.StageDone(name, err)
+		}
+	}()
+	return out
+}
+
+// startLink moves items from one stage to the next through a queue of up
+// to size items, so that the observer sees when an item leaves a stage,
+// when the next one takes it and how many wait in between.
+func (p *pipeline) startLink(from chan interface{}, size int, fromName string, toName string) chan interface{} {
+	if size < 1 {
+		size = 1
+	}
+	to := make(chan interface{})
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(to)
+		queue := []interface{}{}
+		for from != nil || len(queue) > 0 {
+			var receive, deliver chan interface{}
+			var head interface{}
+			if len(queue) < size {
+				receive = from
+			}
+			if len(queue) > 0 {
+				deliver, head = to, queue[0]
+			}
+
+			select {
+			case data, ok := <-receive:
+				if !ok {
+					from = nil
+					continue
+				}
+				at := time.Now()
+				queue = append(queue, data)
+				p.observer.ItemOut(fromName, toName, len(queue), at)
+			case deliver <- head:
+				at := time.Now()
+				queue = queue[1:]
+				p.observer.ItemIn(fromName, toName, len(queue), at)
+			}
+		}
+	}()
+	return to
+}
+
+type firstError struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (f *firstError) set(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+// withContext adapts a plain job. It cannot be interrupted, but the
+// pipeline drains its input and discards its output once ctx is done.
+func withContext(worker job) ctxJob {
+	return func(ctx context.Context, in, out chan interface{}) error {
+		worker(in, out)
+		return nil
+	}
+}
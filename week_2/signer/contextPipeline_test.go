@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fastSigners replaces the signers with ones that do not sleep.
+func fastSigners(t *testing.T) {
+	md5Signer, crc32Signer := DataSignerMd5, DataSignerCrc32
+	DataSignerMd5 = func(data string) string {
+		return fmt.Sprintf("%x", md5.Sum([]byte(data+DataSignerSalt)))
+	}
+	DataSignerCrc32 = func(data string) string {
+		return strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(data+DataSignerSalt))), 10)
+	}
+	t.Cleanup(func() {
+		DataSignerMd5, DataSignerCrc32 = md5Signer, crc32Signer
+	})
+}
+
+// checkGoroutines fails if the number of goroutines does not get back to
+// before in a second.
+func checkGoroutines(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Errorf("goroutines leaked: %d before, %d after", before, runtime.NumGoroutine())
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func source(items ...interface{}) ctxJob {
+	return func(ctx context.Context, in, out chan interface{}) error {
+		for _, item := range items {
+			if err := send(ctx, out, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func collect(result *[]string) ctxJob {
+	return func(ctx context.Context, in, out chan interface{}) error {
+		for data := range in {
+			*result = append(*result, data.(string))
+		}
+		return nil
+	}
+}
+
+func TestPipelineContextSigner(t *testing.T) {
+	fastSigners(t)
+	before := runtime.NumGoroutine()
+
+	result := []string{}
+	err := ExecutePipelineContext(context.Background(),
+		source(0, 1),
+		SingleHashContext,
+		MultiHashContext,
+		CombineResultsContext,
+		collect(&result),
+	)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542"
+	if len(result) != 1 || result[0] != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+
+	checkGoroutines(t, before)
+}
+
+func TestPipelineContextBadInput(t *testing.T) {
+	fastSigners(t)
+	before := runtime.NumGoroutine()
+
+	result := []string{}
+	err := ExecutePipelineContext(context.Background(),
+		source(1, 2.5, 3),
+		SingleHashContext,
+		MultiHashContext,
+		CombineResultsContext,
+		collect(&result),
+	)
+
+	if err == nil || !strings.Contains(err.Error(), "SingleHash: data must be int or string, got float64") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if len(result) != 0 {
+		t.Errorf("result sent after an error: %v", result)
+	}
+
+	checkGoroutines(t, before)
+}
+
+func TestPipelineContextCancelsUpstream(t *testing.T) {
+	before := runtime.NumGoroutine()
+	errStop := errors.New("stop")
+	produced := 0
+
+	infinite := func(ctx context.Context, in, out chan interface{}) error {
+		for i := 0; ; i++ {
+			if err := send(ctx, out, interface{}(i)); err != nil {
+				return err
+			}
+			produced++
+		}
+	}
+
+	failing := func(ctx context.Context, in, out chan interface{}) error {
+		for data := range in {
+			if data.(int) == 10 {
+				return errStop
+			}
+		}
+		return nil
+	}
+
+	sink := func(ctx context.Context, in, out chan interface{}) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	err := ExecutePipelineContext(context.Background(), infinite, failing, sink)
+
+	if !errors.Is(err, errStop) {
+		t.Errorf("expected %v, got %v", errStop, err)
+	}
+
+	if produced < 10 {
+		t.Errorf("produced %d items", produced)
+	}
+
+	checkGoroutines(t, before)
+}
+
+func TestPipelineContextParentCancel(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	ticker := func(ctx context.Context, in, out chan interface{}) error {
+		for {
+			if err := send(ctx, out, interface{}("tick")); err != nil {
+				return err
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	received := 0
+	err := ExecutePipelineContext(ctx, ticker, withContext(func(in, out chan interface{}) {
+		for range in {
+			received++
+		}
+	}))
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+
+	if received == 0 {
+		t.Errorf("nothing received before the deadline")
+	}
+
+	checkGoroutines(t, before)
+}
+
+func TestPipelineContextPanic(t *testing.T) {
+	err := ExecutePipelineContext(context.Background(),
+		source(1.5),
+		withContext(job(CombineResults)),
+	)
+
+	if err == nil || !strings.Contains(err.Error(), "stage panic: CombineResults: data must be int or string") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
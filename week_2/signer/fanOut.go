@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+type sequenced[T any] struct {
+	seq   int
+	value T
+}
+
+// Ordered is a stage that runs f on several items at once but emits the
+// results in input order. Results that are ready before their turn wait
+// in a reorder buffer; window bounds the items being processed plus the
+// ones waiting, so a slow item stops new ones from being taken instead of
+// letting the buffer grow.
+func Ordered[In, Out any](window int, f func(In) Out) Stage[In, Out] {
+	if window < 1 {
+		window = 1
+	}
+
+	return func(ctx context.Context, in chan In, out chan Out) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		errs := &firstError{}
+		slots := make(chan struct{}, window)
+		results := make(chan sequenced[Out])
+
+		go func() {
+			wg := &sync.WaitGroup{}
+			defer close(results)
+			defer wg.Wait()
+
+			for seq := 0; ; seq++ {
+				data, ok, err := receive(ctx, in)
+				if err != nil {
+					errs.set(err)
+					return
+				}
+				if !ok {
+					return
+				}
+
+				select {
+				case <-ctx.Done():
+					errs.set(ctx.Err())
+					return
+				case slots <- struct{}{}:
+				}
+
+				wg.Add(1)
+				go func(seq int, data In) {
+					defer wg.Done()
+					results <- sequenced[Out]{seq: seq, value: f(data)}
+				}(seq, data)
+			}
+		}()
+
+		pending := map[int]Out{}
+		next := 0
+
+		for result := range results {
+			pending[result.seq] = result.value
+
+			for {
+				value, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+
+				err := send(ctx, out, value)
+				if err != nil {
+					errs.set(err)
+					cancel()
+				}
+				<-slots
+			}
+		}
+
+		return errs.err
+	}
+}
+
+// Each is a stage that runs f on every item in a goroutine of its own,
+// results leave in the order they are ready.
+func Each[In, Out any](f func(In) Out) Stage[In, Out] {
+	return func(ctx context.Context, in chan In, out chan Out) error {
+		wg := &sync.WaitGroup{}
+		defer wg.Wait()
+		for {
+			data, ok, err := receive(ctx, in)
+			if err != nil || !ok {
+				return err
+			}
+			wg.Add(1)
+			go func(data In) {
+				defer wg.Done()
+				send(ctx, out, f(data))
+			}(data)
+		}
+	}
+}
+
+// Pool is a stage that runs f on at most workers items at once, results
+// leave in the order they are ready. A slow consumer blocks the workers,
+// which in turn stop taking items from the producer.
+func Pool[In, Out any](workers int, f func(In) Out) Stage[In, Out] {
+	if workers < 1 {
+		workers = 1
+	}
+
+	return func(ctx context.Context, in chan In, out chan Out) error {
+		wg := &sync.WaitGroup{}
+		errs := &firstError{}
+		wg.Add(workers)
+
+		for i := 0; i < workers; i++ {
+			go func() {
+				defer wg.Done()
+				for {
+					data, ok, err := receive(ctx, in)
+					if err != nil {
+						errs.set(err)
+						return
+					}
+					if !ok {
+						return
+					}
+
+					err = send(ctx, out, f(data))
+					if err != nil {
+						errs.set(err)
+						return
+					}
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		return errs.err
+	}
+}
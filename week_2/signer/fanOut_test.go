@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOrderedKeepsInputOrder(t *testing.T) {
+	before := runtime.NumGoroutine()
+	items := []int{5, 4, 3, 2, 1, 0, 5, 4, 3, 2, 1, 0}
+
+	slow := Ordered(4, func(n int) int {
+		time.Sleep(time.Duration(n) * 5 * time.Millisecond)
+		return n * 10
+	})
+
+	start := time.Now()
+	result, err := Run(context.Background(), slow, items)
+	elapsed := time.Since(start)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []int{50, 40, 30, 20, 10, 0, 50, 40, 30, 20, 10, 0}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+
+	// one by one it takes 300ms
+	if elapsed > 200*time.Millisecond {
+		t.Errorf("items were not processed in parallel: %s", elapsed)
+	}
+
+	checkGoroutines(t, before)
+}
+
+func TestOrderedWindow(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var calls int32
+	stage := Ordered(3, func(n int) int {
+		atomic.AddInt32(&calls, 1)
+		return n
+	})
+
+	in := make(chan int)
+	out := make(chan int)
+	go func() {
+		defer close(in)
+		for i := 0; i < 100; i++ {
+			if send(ctx, in, i) != nil {
+				return
+			}
+		}
+	}()
+
+	done := make(chan error)
+	go func() {
+		done <- stage(ctx, in, out)
+	}()
+
+	// nobody reads out, so the stage must stop taking items
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("%d items taken with a window of 3", n)
+	}
+
+	if <-out != 0 {
+		t.Errorf("first item is not the first one")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 4 {
+		t.Errorf("%d items taken after one was read", n)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	checkGoroutines(t, before)
+}
+
+func TestOrderedHashes(t *testing.T) {
+	fastSigners(t)
+
+	signer := Pipe(Pipe(Map(itoa), SingleHashOrdered(8)), MultiHashOrdered(8))
+	result, err := Run(context.Background(), signer, []int{1, 0, 1})
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{
+		"4958044192186797981418233587017209679042592862002427381542",
+		"29568666068035183841425683795340791879727309630931025356555",
+		"4958044192186797981418233587017209679042592862002427381542",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+}
+
+func TestOrderedCancel(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stage := Ordered(2, func(n int) int {
+		if n == 3 {
+			cancel()
+		}
+		return n
+	})
+
+	_, err := Run(ctx, stage, []int{1, 2, 3, 4, 5, 6})
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	checkGoroutines(t, before)
+}
+
+func TestPoolSigner(t *testing.T) {
+	fastSigners(t)
+	before := runtime.NumGoroutine()
+
+	result := []string{}
+	err := ExecutePipelineConfig(context.Background(),
+		StageConfig{Job: source(0, 1, "1", 0), Buffer: 2},
+		StageConfig{Job: SingleHashPool(2), Buffer: 2},
+		StageConfig{Job: MultiHashPool(2), Buffer: 2},
+		StageConfig{Job: CombineResultsContext},
+		StageConfig{Job: collect(&result)},
+	)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "29568666068035183841425683795340791879727309630931025356555_29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542_4958044192186797981418233587017209679042592862002427381542"
+	if len(result) != 1 || result[0] != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+
+	checkGoroutines(t, before)
+}
+
+func TestPoolBackpressure(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var sent, running, maxRunning int32
+	release := make(chan struct{})
+
+	producer := func(ctx context.Context, in, out chan interface{}) error {
+		for i := 0; ; i++ {
+			if err := send(ctx, out, interface{}(i)); err != nil {
+				return err
+			}
+			atomic.AddInt32(&sent, 1)
+		}
+	}
+
+	slow := Pool(2, func(data interface{}) interface{} {
+		current := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			seen := atomic.LoadInt32(&maxRunning)
+			if current <= seen || atomic.CompareAndSwapInt32(&maxRunning, seen, current) {
+				break
+			}
+		}
+		<-release
+		return data
+	})
+
+	done := make(chan error)
+	go func() {
+		done <- ExecutePipelineConfig(ctx,
+			StageConfig{Job: producer, Buffer: 3},
+			StageConfig{Job: slow},
+		)
+	}()
+
+	// two items are held by the workers and three wait in the buffer
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&sent); n != 5 {
+		t.Errorf("producer sent %d items while the consumer was stuck", n)
+	}
+
+	close(release)
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	if n := atomic.LoadInt32(&maxRunning); n != 2 {
+		t.Errorf("%d items processed at once with 2 workers", n)
+	}
+
+	checkGoroutines(t, before)
+}
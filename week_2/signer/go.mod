@@ -1,3 +1,10 @@
 module hw
 
-go 1.17
+go 1.18
+
+require (
+	golang.org/x/crypto v0.17.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.15.0 // indirect
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Graph is a pipeline whose stages form a DAG: a stage may send its items
+// to several stages, each item to every one of them or only to the ones
+// whose condition holds, and a stage may take items from several stages at
+// once. A stage without inputs gets a closed channel like the first stage
+// of ExecutePipeline, the items of a stage without outputs are dropped.
+//
+//	err := NewGraph().
+//		Stage(StageConfig{Name: "source", Job: source}).
+//		Stage(StageConfig{Name: "SingleHash", Job: SingleHashContext}).
+//		Stage(StageConfig{Name: "log", Job: log}).
+//		Connect("source", "SingleHash", "log").
+//		Run(ctx)
+//
+// Builder errors are kept and returned by Validate and Run.
+type Graph struct {
+	nodes  []*graphNode
+	byName map[string]*graphNode
+	err    error
+}
+
+type graphNode struct {
+	stage   StageConfig
+	edges   []graphEdge
+	inputs  int
+	in      chan interface{}
+	senders *sync.WaitGroup
+}
+
+type graphEdge struct {
+	to   string
+	when func(data interface{}) bool
+}
+
+func NewGraph() *Graph {
+	return &Graph{byName: map[string]*graphNode{}}
+}
+
+func (g *Graph) fail(err error) *Graph {
+	if g.err == nil {
+		g.err = err
+	}
+	return g
+}
+
+// Stage adds a stage, its Name is how edges refer to it.
+func (g *Graph) Stage(stage StageConfig) *Graph {
+	if stage.Name == "" {
+		return g.fail(fmt.Errorf("graph: stage has no name"))
+	}
+	if _, ok := g.byName[stage.Name]; ok {
+		return g.fail(fmt.Errorf("graph: duplicate stage %q", stage.Name))
+	}
+	node := &graphNode{stage: stage}
+	g.nodes = append(g.nodes, node)
+	g.byName[stage.Name] = node
+	return g
+}
+
+// Connect sends every item of from to each of to.
+func (g *Graph) Connect(from string, to ...string) *Graph {
+	for _, name := range to {
+		g.Route(from, name, nil)
+	}
+	return g
+}
+
+// Route sends the items of from for which when returns true to to. An
+// item is sent to every stage whose route takes it, and dropped if none
+// does.
+func (g *Graph) Route(from string, to string, when func(data interface{}) bool) *Graph {
+	node, ok := g.byName[from]
+	if !ok {
+		return g.fail(fmt.Errorf("graph: unknown stage %q", from))
+	}
+	target, ok := g.byName[to]
+	if !ok {
+		return g.fail(fmt.Errorf("graph: unknown stage %q", to))
+	}
+	for _, edge := range node.edges {
+		if edge.to == to {
+			return g.fail(fmt.Errorf("graph: %s is already connected to %s", from, to))
+		}
+	}
+	node.edges = append(node.edges, graphEdge{to: to, when: when})
+	target.inputs++
+	return g
+}
+
+// Validate returns the first builder error, or an error naming a cycle if
+// there is one.
+func (g *Graph) Validate() error {
+	if g.err != nil {
+		return g.err
+	}
+	if len(g.nodes) == 0 {
+		return fmt.Errorf("graph: no stages")
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[string]int{}
+	path := []string{}
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			start := 0
+			for path[start] != name {
+				start++
+			}
+			cycle := append(append([]string{}, path[start:]...), name)
+			return fmt.Errorf("graph: cycle %s", strings.Join(cycle, " -> "))
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+		for _, edge := range g.byName[name].edges {
+			err := visit(edge.to)
+			if err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+
+	for _, node := range g.nodes {
+		err := visit(node.stage.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run validates the graph and runs it like ExecutePipelineConfig: the
+// first error cancels all the stages and is returned once every stage has
+// finished.
+func (g *Graph) Run(ctx context.Context) error {
+	err := g.Validate()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	p := &pipeline{
+		ctx:    ctx,
+		cancel: cancel,
+		wg:     &sync.WaitGroup{},
+		errs:   &firstError{},
+	}
+
+	for _, node := range g.nodes {
+		node.in = make(chan interface{})
+		node.senders = &sync.WaitGroup{}
+		node.senders.Add(node.inputs)
+		go func(node *graphNode) {
+			node.senders.Wait()
+			close(node.in)
+		}(node)
+	}
+
+	for _, node := range g.nodes {
+		out := p.startStage(node.stage.Job, node.stage.Name, node.stage.Buffer, node.in)
+		p.wg.Add(1)
+		go g.dispatch(p, node, out)
+	}
+
+	p.wg.Wait()
+
+	if p.errs.err != nil {
+		return p.errs.err
+	}
+
+	return ctx.Err()
+}
+
+// dispatch sends the items of node to the stages it is connected to. Once
+// ctx is done it only drains out.
+func (g *Graph) dispatch(p *pipeline, node *graphNode, out chan interface{}) {
+	defer p.wg.Done()
+	defer func() {
+		for _, edge := range node.edges {
+			g.byName[edge.to].senders.Done()
+		}
+	}()
+
+	for data := range out {
+		for _, edge := range node.edges {
+			if edge.when != nil && !edge.when(data) {
+				continue
+			}
+			send(p.ctx, g.byName[edge.to].in, data)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
+	"sort"
+	"testing"
+)
+
+func collectInts(result *[]int) ctxJob {
+	return func(ctx context.Context, in, out chan interface{}) error {
+		for data := range in {
+			*result = append(*result, data.(int))
+		}
+		sort.Ints(*result)
+		return nil
+	}
+}
+
+func TestGraph(t *testing.T) {
+	before := runtime.NumGoroutine()
+	double := Map(func(data interface{}) (interface{}, error) {
+		return data.(int) * 2, nil
+	})
+	negate := Map(func(data interface{}) (interface{}, error) {
+		return -data.(int), nil
+	})
+	even := func(data interface{}) bool {
+		return data.(int)%2 == 0
+	}
+	odd := func(data interface{}) bool {
+		return !even(data)
+	}
+
+	merged, evens, odds := []int{}, []int{}, []int{}
+	err := NewGraph().
+		Stage(StageConfig{Name: "source", Job: source(1, 2, 3, 4, 5)}).
+		Stage(StageConfig{Name: "double", Job: double, Buffer: 2}).
+		Stage(StageConfig{Name: "negate", Job: negate}).
+		Stage(StageConfig{Name: "merged", Job: collectInts(&merged)}).
+		Stage(StageConfig{Name: "evens", Job: collectInts(&evens)}).
+		Stage(StageConfig{Name: "odds", Job: collectInts(&odds)}).
+		Connect("source", "double", "negate").
+		Connect("double", "merged").
+		Connect("negate", "merged").
+		Route("source", "evens", even).
+		Route("source", "odds", odd).
+		Run(context.Background())
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []int{-5, -4, -3, -2, -1, 2, 4, 6, 8, 10}; !reflect.DeepEqual(merged, expected) {
+		t.Errorf("merged not match\nGot: %v\nExpected: %v", merged, expected)
+	}
+	if !reflect.DeepEqual(evens, []int{2, 4}) || !reflect.DeepEqual(odds, []int{1, 3, 5}) {
+		t.Errorf("routed not match: evens %v, odds %v", evens, odds)
+	}
+	checkGoroutines(t, before)
+}
+
+func TestGraphSigner(t *testing.T) {
+	fastSigners(t)
+
+	combined, single := []string{}, []string{}
+	err := NewGraph().
+		Stage(StageConfig{Name: "source", Job: source(0, 1)}).
+		Stage(StageConfig{Name: "SingleHash", Job: SingleHashContext}).
+		Stage(StageConfig{Name: "MultiHash", Job: MultiHashContext}).
+		Stage(StageConfig{Name: "CombineResults", Job: CombineResultsContext}).
+		Stage(StageConfig{Name: "combined", Job: collect(&combined)}).
+		Stage(StageConfig{Name: "single", Job: collect(&single)}).
+		Connect("source", "SingleHash").
+		Connect("SingleHash", "MultiHash", "single").
+		Connect("MultiHash", "CombineResults").
+		Connect("CombineResults", "combined").
+		Run(context.Background())
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542"
+	if len(combined) != 1 || combined[0] != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", combined, expected)
+	}
+	sort.Strings(single)
+	if !reflect.DeepEqual(single, []string{"2212294583~709660146", "4108050209~502633748"}) {
+		t.Errorf("unexpected SingleHash results %v", single)
+	}
+}
+
+func TestGraphValidate(t *testing.T) {
+	pass := withContext(func(in, out chan interface{}) {
+		for data := range in {
+			out <- data
+		}
+	})
+	stages := func() *Graph {
+		return NewGraph().
+			Stage(StageConfig{Name: "a", Job: pass}).
+			Stage(StageConfig{Name: "b", Job: pass}).
+			Stage(StageConfig{Name: "c", Job: pass}).
+			Stage(StageConfig{Name: "d", Job: pass})
+	}
+
+	cases := map[string]*Graph{
+		"graph: cycle b -> c -> d -> b":      stages().Connect("a", "b").Connect("b", "c").Connect("c", "d").Connect("d", "b"),
+		"graph: cycle a -> a":                stages().Connect("a", "a"),
+		`graph: unknown stage "e"`:           stages().Connect("a", "e"),
+		`graph: duplicate stage "a"`:         stages().Stage(StageConfig{Name: "a", Job: pass}),
+		"graph: stage has no name":           stages().Stage(StageConfig{Job: pass}),
+		"graph: a is already connected to b": stages().Connect("a", "b").Route("a", "b", nil),
+		"graph: no stages":                   NewGraph(),
+	}
+	for expected, graph := range cases {
+		err := graph.Run(context.Background())
+		if err == nil || err.Error() != expected {
+			t.Errorf("expected %q, got %v", expected, err)
+		}
+	}
+
+	if err := stages().Connect("a", "b", "c").Connect("b", "d").Connect("c", "d").Validate(); err != nil {
+		t.Errorf("diamond is not a cycle: %v", err)
+	}
+}
+
+func TestGraphError(t *testing.T) {
+	before := runtime.NumGoroutine()
+	errStop := errors.New("stop")
+
+	failing := func(ctx context.Context, in, out chan interface{}) error {
+		<-in
+		return errStop
+	}
+	endless := func(ctx context.Context, in, out chan interface{}) error {
+		for i := 0; ; i++ {
+			if err := send(ctx, out, interface{}(i)); err != nil {
+				return err
+			}
+		}
+	}
+	count := 0
+	counter := func(ctx context.Context, in, out chan interface{}) error {
+		for range in {
+			count++
+		}
+		return nil
+	}
+
+	err := NewGraph().
+		Stage(StageConfig{Name: "source", Job: endless}).
+		Stage(StageConfig{Name: "failing", Job: failing}).
+		Stage(StageConfig{Name: "counter", Job: counter}).
+		Connect("source", "failing", "counter").
+		Run(context.Background())
+
+	if !errors.Is(err, errStop) {
+		t.Fatalf("expected %v, got %v", errStop, err)
+	}
+	checkGoroutines(t, before)
+}
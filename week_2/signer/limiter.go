@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter models a quota of an external signer. Acquire blocks until a call
+// is allowed, Release is called when the call is done.
+type Limiter interface {
+	Acquire(ctx context.Context) error
+	Release()
+	Stats() LimiterStats
+}
+
+// LimiterStats tells how long callers waited for a limiter.
+type LimiterStats struct {
+	Acquired  int64
+	Waited    int64
+	TotalWait time.Duration
+	MaxWait   time.Duration
+}
+
+type waitStats struct {
+	mu    sync.Mutex
+	stats LimiterStats
+}
+
+func (w *waitStats) record(wait time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stats.Acquired++
+	if wait > 0 {
+		w.stats.Waited++
+		w.stats.TotalWait += wait
+	}
+	if wait > w.stats.MaxWait {
+		w.stats.MaxWait = wait
+	}
+}
+
+func (w *waitStats) Stats() LimiterStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stats
+}
+
+// Semaphore allows at most n calls at once.
+type Semaphore struct {
+	waitStats
+	slots chan struct{}
+}
+
+func NewSemaphore(n int) *Semaphore {
+	if n < 1 {
+		n = 1
+	}
+	return &Semaphore{slots: make(chan struct{}, n)}
+}
+
+func (s *Semaphore) Acquire(ctx context.Context) error {
+	select {
+	case s.slots <- struct{}{}:
+		s.record(0)
+		return nil
+	default:
+	}
+
+	start := time.Now()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case s.slots <- struct{}{}:
+		s.record(time.Since(start))
+		return nil
+	}
+}
+
+func (s *Semaphore) Release() {
+	<-s.slots
+}
+
+// TokenBucket allows rate calls per second on average and bursts of up to
+// burst calls. A caller that finds the bucket empty sleeps until the next
+// token is due instead of polling.
+type TokenBucket struct {
+	waitStats
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if rate <= 0 {
+		panic("non-positive rate for NewTokenBucket")
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+func (b *TokenBucket) Acquire(ctx context.Context) error {
+	start := time.Now()
+	waited := time.Duration(0)
+
+	for {
+		wait := b.take()
+		if wait == 0 {
+			b.record(waited)
+			return nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		waited = time.Since(start)
+	}
+}
+
+// take takes a token if there is one, otherwise it returns how long until
+// the next one.
+func (b *TokenBucket) take() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+
+	wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	if wait < time.Nanosecond {
+		wait = time.Nanosecond
+	}
+	return wait
+}
+
+func (b *TokenBucket) Release() {
+}
+
+// Limiters combines several limits, e.g. a rate and a concurrency quota.
+// They are acquired in order and released in reverse.
+type Limiters []Limiter
+
+func (l Limiters) Acquire(ctx context.Context) error {
+	for i, limiter := range l {
+		err := limiter.Acquire(ctx)
+		if err != nil {
+			l[:i].Release()
+			return err
+		}
+	}
+	return nil
+}
+
+func (l Limiters) Release() {
+	for i := len(l) - 1; i >= 0; i-- {
+		l[i].Release()
+	}
+}
+
+// Stats sums the stats of all the limiters.
+func (l Limiters) Stats() LimiterStats {
+	total := LimiterStats{}
+	for _, limiter := range l {
+		stats := limiter.Stats()
+		total.Acquired += stats.Acquired
+		total.Waited += stats.Waited
+		total.TotalWait += stats.TotalWait
+		if stats.MaxWait > total.MaxWait {
+			total.MaxWait = stats.MaxWait
+		}
+	}
+	return total
+}
+
+// Limit wraps a signer so that every call goes through limiter. The signer
+// is looked up on every call, so it may be one of the DataSigner variables.
+func Limit(signer *func(string) string, limiter Limiter) func(string) string {
+	return func(data string) string {
+		if limiter == nil {
+			return (*signer)(data)
+		}
+		limiter.Acquire(context.Background())
+		defer limiter.Release()
+		return (*signer)(data)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	bucket := NewTokenBucket(100, 2)
+
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if err := bucket.Acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		bucket.Release()
+	}
+	elapsed := time.Since(start)
+
+	// two calls go right away, the other four wait 10ms each
+	if elapsed < 35*time.Millisecond || elapsed > 200*time.Millisecond {
+		t.Errorf("6 calls at 100/s with a burst of 2 took %s", elapsed)
+	}
+
+	stats := bucket.Stats()
+	if stats.Acquired != 6 || stats.Waited != 4 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if stats.TotalWait < 35*time.Millisecond || stats.MaxWait < 5*time.Millisecond {
+		t.Errorf("wait time not recorded: %+v", stats)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	slow := NewTokenBucket(0.1, 1)
+	slow.Acquire(ctx)
+	if err := slow.Acquire(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestSemaphoreLimit(t *testing.T) {
+	sem := NewSemaphore(2)
+	var running, maxRunning int32
+
+	signer := func(data string) string {
+		current := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			seen := atomic.LoadInt32(&maxRunning)
+			if current <= seen || atomic.CompareAndSwapInt32(&maxRunning, seen, current) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return data + "!"
+	}
+	limited := Limit(&signer, sem)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if limited("x") != "x!" {
+				t.Errorf("signer result changed")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxRunning != 2 {
+		t.Errorf("%d calls at once with a limit of 2", maxRunning)
+	}
+
+	stats := sem.Stats()
+	if stats.Acquired != 6 || stats.Waited != 4 || stats.TotalWait <= 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestLimitersRelease(t *testing.T) {
+	first := NewSemaphore(1)
+	second := NewSemaphore(1)
+	both := Limiters{first, second}
+
+	if err := both.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := (Limiters{NewSemaphore(1), second}).Acquire(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+
+	both.Release()
+	if err := both.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	both.Release()
+
+	if stats := both.Stats(); stats.Acquired != 4 || stats.Waited != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestHashLimiters(t *testing.T) {
+	fastSigners(t)
+	md5Limiter, crc32Limiter := Md5Limiter, Crc32Limiter
+	defer func() {
+		Md5Limiter, Crc32Limiter = md5Limiter, crc32Limiter
+	}()
+
+	Md5Limiter = NewSemaphore(1)
+	Crc32Limiter = Limiters{NewTokenBucket(1000, 8), NewSemaphore(4)}
+
+	result, err := Run(context.Background(), Pipe(Pipe(Map(itoa), SingleHashStage), MultiHashStage), []int{0, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 2 {
+		t.Errorf("unexpected result %v", result)
+	}
+
+	if n := Md5Limiter.Stats().Acquired; n != 2 {
+		t.Errorf("md5 limiter acquired %d times", n)
+	}
+	// 2 crc32 calls in SingleHash and 6 in MultiHash per item, counted by
+	// both limiters
+	if n := Crc32Limiter.Stats().Acquired; n != 32 {
+		t.Errorf("crc32 limiters acquired %d times", n)
+	}
+}
+
+func TestTokenBucketRate(t *testing.T) {
+	for _, rate := range []float64{0, -1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("rate %v is accepted", rate)
+				}
+			}()
+			NewTokenBucket(rate, 1)
+		}()
+	}
+}
+
+func TestMd5LimiterQuota(t *testing.T) {
+	md5Limiter, crc32Signer, md5Signer := Md5Limiter, DataSignerCrc32, DataSignerMd5
+	defer func() {
+		Md5Limiter, DataSignerCrc32, DataSignerMd5 = md5Limiter, crc32Signer, md5Signer
+	}()
+
+	var running, maxRunning int32
+	full := make(chan struct{})
+	once := &sync.Once{}
+	DataSignerCrc32 = func(data string) string {
+		return data
+	}
+	// every call waits until the quota is used up, so the first 4 calls
+	// are running together however slowly the items arrive
+	DataSignerMd5 = func(data string) string {
+		current := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			seen := atomic.LoadInt32(&maxRunning)
+			if current <= seen || atomic.CompareAndSwapInt32(&maxRunning, seen, current) {
+				break
+			}
+		}
+		if current == 4 {
+			once.Do(func() { close(full) })
+		}
+		select {
+		case <-full:
+		case <-time.After(time.Second):
+		}
+		return data
+	}
+	Md5Limiter = NewSemaphore(4)
+
+	items := []int{0, 1, 2, 3, 4, 5, 6, 7}
+	if _, err := Run(context.Background(), Pipe(Map(itoa), SingleHashStage), items); err != nil {
+		t.Fatal(err)
+	}
+
+	if maxRunning != 4 {
+		t.Errorf("%d md5 calls ran at once with a quota of 4", maxRunning)
+	}
+	// the first 4 calls find a free slot, the rest may have to wait for one
+	if stats := Md5Limiter.Stats(); stats.Acquired != 8 || stats.Waited > 4 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
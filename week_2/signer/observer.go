@@ -0,0 +1,272 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Observer receives the events of ExecutePipelineObserved. ItemOut is an
+// item leaving stage from, ItemIn stage to taking it; queue is the number
+// of items waiting between the two afterwards and at is when it happened.
+// Calls come from several goroutines at once, so an item may be reported
+// leaving a stage before it is reported entering it; at keeps the order.
+type Observer interface {
+	StageStarted(stage string)
+	ItemOut(from string, to string, queue int, at time.Time)
+	ItemIn(from string, to string, queue int, at time.Time)
+	StageDone(stage string, err error)
+}
+
+// latencyBuckets are the upper bounds of the latency histogram buckets,
+// the last bucket has no bound.
+var latencyBuckets = []time.Duration{
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+type Histogram struct {
+	Counts []int64         `json:"counts"`
+	Count  int64           `json:"count"`
+	Sum    time.Duration   `json:"sum"`
+	Max    time.Duration   `json:"max"`
+	Bounds []time.Duration `json:"bounds"`
+}
+
+func newHistogram() Histogram {
+	return Histogram{Counts: make([]int64, len(latencyBuckets)+1), Bounds: latencyBuckets}
+}
+
+func (h *Histogram) add(d time.Duration) {
+	i := 0
+	for i < len(h.Bounds) && d > h.Bounds[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Count++
+	h.Sum += d
+	if d > h.Max {
+		h.Max = d
+	}
+}
+
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns the upper bound of the bucket holding the q-th
+// quantile, or the maximum for the last bucket.
+func (h Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := int64(q*float64(h.Count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	seen := int64(0)
+	for i, n := range h.Counts {
+		seen += n
+		if seen >= rank {
+			if i < len(h.Bounds) && h.Bounds[i] < h.Max {
+				return h.Bounds[i]
+			}
+			return h.Max
+		}
+	}
+	return h.Max
+}
+
+// StageMetrics is what Metrics knows about one stage. Queue is the number
+// of items waiting for the stage. Latency is the time from an item entering
+// the stage to the next item leaving it, which is the processing time for
+// stages that emit one item per input.
+type StageMetrics struct {
+	Name     string    `json:"name"`
+	In       int64     `json:"in"`
+	Out      int64     `json:"out"`
+	Queue    int       `json:"queue"`
+	MaxQueue int       `json:"max_queue"`
+	Running  bool      `json:"running"`
+	Error    string    `json:"error,omitempty"`
+	Latency  Histogram `json:"latency"`
+
+	// entered are the times of items waiting for a result to leave, left
+	// the times of results reported before the item they came from; a
+	// stage nothing is sent to keeps no times, and a stage that does not
+	// emit one item per input, like a sink, keeps the last maxPending
+	entered []time.Time
+	left    []time.Time
+	fed     bool
+}
+
+// maxPending bounds the times a stage keeps to pair inputs with results.
+// It is well above the number of items a stage works on at once.
+const maxPending = 1024
+
+// pending appends at to times, dropping the oldest time if there are
+// maxPending already.
+func pending(times []time.Time, at time.Time) []time.Time {
+	if len(times) >= maxPending {
+		times = times[len(times)-maxPending+1:]
+	}
+	return append(times, at)
+}
+
+// MetricsSnapshot is a copy of the metrics, it is also what the expvar
+// variable shows.
+type MetricsSnapshot struct {
+	Stages        []StageMetrics `json:"stages"`
+	Goroutines    int            `json:"goroutines"`
+	MaxGoroutines int            `json:"max_goroutines"`
+}
+
+// Metrics is the built-in Observer. Goroutines are counted for the whole
+// process, sampled on every event.
+type Metrics struct {
+	mu       sync.Mutex
+	stages   []*StageMetrics
+	byName   map[string]*StageMetrics
+	snapshot MetricsSnapshot
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{byName: map[string]*StageMetrics{}}
+}
+
+func (m *Metrics) stage(name string) *StageMetrics {
+	s, ok := m.byName[name]
+	if !ok {
+		s = &StageMetrics{Name: name, Latency: newHistogram()}
+		m.byName[name] = s
+		m.stages = append(m.stages, s)
+	}
+	return s
+}
+
+func (m *Metrics) sample() {
+	n := runtime.NumGoroutine()
+	m.snapshot.Goroutines = n
+	if n > m.snapshot.MaxGoroutines {
+		m.snapshot.MaxGoroutines = n
+	}
+}
+
+func (m *Metrics) StageStarted(stage string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stage(stage).Running = true
+	m.sample()
+}
+
+func (m *Metrics) ItemOut(from string, to string, queue int, at time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.stage(from)
+	s.Out++
+	if len(s.entered) > 0 {
+		s.Latency.add(latency(s.entered[0], at))
+		s.entered = s.entered[1:]
+	} else if s.fed {
+		s.left = pending(s.left, at)
+	}
+	next := m.stage(to)
+	next.fed = true
+	next.Queue = queue
+	if queue > next.MaxQueue {
+		next.MaxQueue = queue
+	}
+	m.sample()
+}
+
+func (m *Metrics) ItemIn(from string, to string, queue int, at time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.stage(to)
+	s.In++
+	s.Queue = queue
+	if len(s.left) > 0 {
+		s.Latency.add(latency(at, s.left[0]))
+		s.left = s.left[1:]
+	} else {
+		s.entered = pending(s.entered, at)
+	}
+	m.sample()
+}
+
+// latency is the time from in to out. The times are taken by different
+// goroutines right after the handoffs, so out may be a bit before in.
+func latency(in time.Time, out time.Time) time.Duration {
+	d := out.Sub(in)
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+func (m *Metrics) StageDone(stage string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.stage(stage)
+	s.Running = false
+	if err != nil {
+		s.Error = err.Error()
+	}
+	m.sample()
+}
+
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := m.snapshot
+	snapshot.Stages = make([]StageMetrics, len(m.stages))
+	for i, s := range m.stages {
+		snapshot.Stages[i] = *s
+		snapshot.Stages[i].entered = nil
+		snapshot.Stages[i].left = nil
+		snapshot.Stages[i].Latency.Counts = append([]int64(nil), s.Latency.Counts...)
+	}
+	return snapshot
+}
+
+// WriteReport prints a table with a line per stage.
+func (m *Metrics) WriteReport(out io.Writer) error {
+	snapshot := m.Snapshot()
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+
+	fmt.Fprintln(w, "stage\tin\tout\tqueue\tmax queue\tmean\tp50\tp99\tmax\t")
+	for _, s := range snapshot.Stages {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\t%s\t%s\t%s\t\n",
+			s.Name, s.In, s.Out, s.Queue, s.MaxQueue,
+			s.Latency.Mean().Round(time.Microsecond),
+			s.Latency.Quantile(0.5).Round(time.Microsecond),
+			s.Latency.Quantile(0.99).Round(time.Microsecond),
+			s.Latency.Max.Round(time.Microsecond))
+	}
+
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(out, "goroutines: %d, max %d\n", snapshot.Goroutines, snapshot.MaxGoroutines)
+	return err
+}
+
+// Publish exports the metrics as an expvar variable, so they are served
+// as JSON on /debug/vars. Like expvar.Publish it panics if name is taken.
+func (m *Metrics) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return m.Snapshot()
+	}))
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	fastSigners(t)
+	metrics := NewMetrics()
+
+	result := []string{}
+	err := ExecutePipelineObserved(context.Background(), metrics,
+		StageConfig{Name: "source", Job: source(0, 1, 2, 3), Buffer: 4},
+		StageConfig{Name: "SingleHash", Job: SingleHashPool(2)},
+		StageConfig{Name: "MultiHash", Job: MultiHashPool(2), Buffer: 2},
+		StageConfig{Name: "CombineResults", Job: CombineResultsContext},
+		StageConfig{Name: "collect", Job: collect(&result)},
+	)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != 1 {
+		t.Fatalf("unexpected result %v", result)
+	}
+
+	snapshot := metrics.Snapshot()
+	counts := []string{}
+	for _, s := range snapshot.Stages {
+		counts = append(counts, fmt.Sprintf("%s %d %d", s.Name, s.In, s.Out))
+		if s.Running {
+			t.Errorf("%s is still running", s.Name)
+		}
+		if s.Queue != 0 {
+			t.Errorf("%s has %d items left in the queue", s.Name, s.Queue)
+		}
+	}
+
+	expected := "source 0 4, SingleHash 4 4, MultiHash 4 4, CombineResults 4 1, collect 1 0"
+	if got := strings.Join(counts, ", "); got != expected {
+		t.Errorf("counts not match\nGot: %v\nExpected: %v", got, expected)
+	}
+
+	if q := snapshot.Stages[1].MaxQueue; q < 1 || q > 4 {
+		t.Errorf("SingleHash max queue %d, buffer is 4", q)
+	}
+	if snapshot.Stages[1].Latency.Count != 4 {
+		t.Errorf("SingleHash latency has %d samples", snapshot.Stages[1].Latency.Count)
+	}
+	if snapshot.MaxGoroutines < 5 {
+		t.Errorf("max goroutines %d", snapshot.MaxGoroutines)
+	}
+
+	report := new(bytes.Buffer)
+	if err := metrics.WriteReport(report); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"stage", "max queue", "p99", "SingleHash", "CombineResults", "goroutines:"} {
+		if !strings.Contains(report.String(), want) {
+			t.Errorf("report has no %q:\n%s", want, report.String())
+		}
+	}
+
+	// expvar names can not be reused, and the test may run several times
+	name := fmt.Sprintf("signer_test_pipeline_%d", time.Now().UnixNano())
+	metrics.Publish(name)
+	published := MetricsSnapshot{}
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &published); err != nil {
+		t.Fatal(err)
+	}
+	if len(published.Stages) != 5 || published.Stages[2].Name != "MultiHash" || published.Stages[2].In != 4 {
+		t.Errorf("unexpected expvar value %+v", published)
+	}
+}
+
+func TestMetricsLatencyAndErrors(t *testing.T) {
+	metrics := NewMetrics()
+	errStop := errors.New("stop")
+
+	slow := func(ctx context.Context, in, out chan interface{}) error {
+		for data := range in {
+			time.Sleep(20 * time.Millisecond)
+			if data.(int) == 3 {
+				return errStop
+			}
+			out <- data
+		}
+		return nil
+	}
+
+	err := ExecutePipelineObserved(context.Background(), metrics,
+		StageConfig{Name: "source", Job: source(1, 2, 3, 4)},
+		StageConfig{Name: "slow", Job: slow},
+		StageConfig{Name: "sink", Job: withContext(func(in, out chan interface{}) {
+			for range in {
+			}
+		})},
+	)
+
+	if !errors.Is(err, errStop) {
+		t.Fatalf("expected %v, got %v", errStop, err)
+	}
+
+	slowMetrics := metrics.Snapshot().Stages[1]
+	// the item after the failed one may be drained or never sent
+	if slowMetrics.Error != "stop" || slowMetrics.In < 3 || slowMetrics.Out != 2 {
+		t.Errorf("unexpected metrics %+v", slowMetrics)
+	}
+	latency := slowMetrics.Latency
+	if latency.Count != 2 || latency.Mean() < 20*time.Millisecond || latency.Quantile(0.5) != latency.Max {
+		t.Errorf("unexpected latency %+v", latency)
+	}
+}
+
+func TestMetricsOutOfOrder(t *testing.T) {
+	metrics := NewMetrics()
+	start := time.Now()
+
+	metrics.ItemOut("source", "hash", 1, start)
+	// the result is reported before the item it came from
+	metrics.ItemOut("hash", "collect", 1, start.Add(30*time.Millisecond))
+	metrics.ItemIn("source", "hash", 0, start.Add(10*time.Millisecond))
+	metrics.ItemIn("hash", "collect", 0, start.Add(40*time.Millisecond))
+
+	metrics.ItemOut("source", "hash", 1, start.Add(50*time.Millisecond))
+	metrics.ItemIn("source", "hash", 0, start.Add(60*time.Millisecond))
+	metrics.ItemOut("hash", "collect", 1, start.Add(65*time.Millisecond))
+
+	latency := metrics.Snapshot().Stages[1].Latency
+	if latency.Count != 2 || latency.Sum != 25*time.Millisecond || latency.Max != 20*time.Millisecond {
+		t.Errorf("unexpected latency %+v", latency)
+	}
+	if source := metrics.byName["source"]; len(source.left) != 0 {
+		t.Errorf("source keeps %d times", len(source.left))
+	}
+}
+
+func TestMetricsPendingBounded(t *testing.T) {
+	metrics := NewMetrics()
+	start := time.Now()
+
+	// print takes every item and emits none, split emits two per input
+	for i := 0; i < 10*maxPending; i++ {
+		at := start.Add(time.Duration(i) * time.Millisecond)
+		metrics.ItemOut("source", "split", 1, at)
+		metrics.ItemIn("source", "split", 0, at)
+		metrics.ItemOut("split", "print", 1, at)
+		metrics.ItemOut("split", "print", 2, at)
+		metrics.ItemIn("split", "print", 1, at)
+		metrics.ItemIn("split", "print", 0, at)
+	}
+
+	if print := metrics.byName["print"]; len(print.entered) > maxPending || cap(print.entered) > 2*maxPending {
+		t.Errorf("print keeps %d times in %d", len(print.entered), cap(print.entered))
+	}
+	if split := metrics.byName["split"]; len(split.left) > maxPending || cap(split.left) > 2*maxPending {
+		t.Errorf("split keeps %d times in %d", len(split.left), cap(split.left))
+	}
+
+	stages := metrics.Snapshot().Stages
+	if stages[2].In != int64(20*maxPending) || stages[1].Out != int64(20*maxPending) {
+		t.Errorf("unexpected metrics %+v", stages)
+	}
+}
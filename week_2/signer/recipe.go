@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+	"gopkg.in/yaml.v3"
+)
+
+// Algorithms are the hash functions a recipe can name. crc32 and md5 are
+// DataSignerCrc32 and DataSignerMd5 behind their limiters and caches, the
+// others add DataSignerSalt the same way. More can be added before
+// compiling a recipe.
+var Algorithms = map[string]func(string) string{
+	"crc32": func(data string) string {
+		return crc32Signer()(data)
+	},
+	"md5": func(data string) string {
+		return md5Signer()(data)
+	},
+	"sha256": func(data string) string {
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(data+DataSignerSalt)))
+	},
+	"blake2b": func(data string) string {
+		return fmt.Sprintf("%x", blake2b.Sum256([]byte(data+DataSignerSalt)))
+	},
+}
+
+// Recipe describes the signing stages, it is read from YAML or JSON:
+//
+//	stages:
+//	  - name: SingleHash
+//	    join: "~"
+//	    parts:
+//	      - hash: [crc32]
+//	      - hash: [md5, crc32]
+//
+// DefaultRecipe is what SingleHash, MultiHash and CombineResults do.
+type Recipe struct {
+	Stages []RecipeStage `yaml:"stages" json:"stages"`
+}
+
+// RecipeStage hashes every item into its Parts joined with Join. With
+// Combine set it instead sorts all the items and joins them with Join.
+// Workers bounds the items hashed at once, 0 means no bound. With Ordered
+// set hashes leave in input order and Workers is the window of Ordered.
+type RecipeStage struct {
+	Name    string       `yaml:"name" json:"name"`
+	Join    string       `yaml:"join,omitempty" json:"join,omitempty"`
+	Parts   []RecipePart `yaml:"parts,omitempty" json:"parts,omitempty"`
+	Combine bool         `yaml:"combine,omitempty" json:"combine,omitempty"`
+	Workers int          `yaml:"workers,omitempty" json:"workers,omitempty"`
+	Ordered bool         `yaml:"ordered,omitempty" json:"ordered,omitempty"`
+}
+
+// RecipePart applies the algorithms of Hash one after another to Prefix
+// followed by the item. A part with Prefixes is repeated for each of them.
+// Parts are computed concurrently.
+type RecipePart struct {
+	Prefix   string   `yaml:"prefix,omitempty" json:"prefix,omitempty"`
+	Prefixes []string `yaml:"prefixes,omitempty" json:"prefixes,omitempty"`
+	Hash     []string `yaml:"hash" json:"hash"`
+}
+
+var DefaultRecipe = Recipe{Stages: []RecipeStage{
+	{
+		Name: "SingleHash",
+		Join: "~",
+		Parts: []RecipePart{
+			{Hash: []string{"crc32"}},
+			{Hash: []string{"md5", "crc32"}},
+		},
+	},
+	{
+		Name: "MultiHash",
+		Parts: []RecipePart{
+			{Prefixes: []string{"0", "1", "2", "3", "4", "5"}, Hash: []string{"crc32"}},
+		},
+	},
+	{
+		Name:    "CombineResults",
+		Join:    "_",
+		Combine: true,
+	},
+}}
+
+// ParseRecipe reads a recipe from YAML, JSON is read as well being a
+// subset of it. Unknown fields are an error.
+func ParseRecipe(data []byte) (Recipe, error) {
+	recipe := Recipe{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err := decoder.Decode(&recipe)
+	if err != nil {
+		return recipe, fmt.Errorf("recipe: %v", err)
+	}
+	return recipe, nil
+}
+
+func LoadRecipe(path string) (Recipe, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Recipe{}, err
+	}
+	return ParseRecipe(data)
+}
+
+// Compile turns the recipe into stages for ExecutePipelineConfig. Like the
+// Context stages they take ints and strings.
+func (r Recipe) Compile() ([]StageConfig, error) {
+	return r.CompileCheckpoint(nil)
+}
+
+// CompileCheckpoint is Compile with hash stages that skip the items
+// checkpoint has and record the others, see Checkpoint.
+func (r Recipe) CompileCheckpoint(checkpoint *Checkpoint) ([]StageConfig, error) {
+	if len(r.Stages) == 0 {
+		return nil, fmt.Errorf("recipe: no stages")
+	}
+
+	stages := make([]StageConfig, len(r.Stages))
+	for i, s := range r.Stages {
+		stage, err := s.compile(s.label(i), checkpoint)
+		if err != nil {
+			return nil, fmt.Errorf("recipe: stage %s: %v", s.label(i), err)
+		}
+		stages[i] = StageConfig{Name: s.Name, Job: untypedHash(s.label(i), stage)}
+	}
+	return stages, nil
+}
+
+// Stage compiles the recipe into a single typed stage.
+func (r Recipe) Stage() (Stage[string, string], error) {
+	if len(r.Stages) == 0 {
+		return nil, fmt.Errorf("recipe: no stages")
+	}
+
+	var result Stage[string, string]
+	for i, s := range r.Stages {
+		stage, err := s.compile(s.label(i), nil)
+		if err != nil {
+			return nil, fmt.Errorf("recipe: stage %s: %v", s.label(i), err)
+		}
+		if result == nil {
+			result = stage
+		} else {
+			result = Pipe(result, stage)
+		}
+	}
+	return result, nil
+}
+
+func (s RecipeStage) label(i int) string {
+	if s.Name == "" {
+		return strconv.Itoa(i)
+	}
+	return s.Name
+}
+
+func (s RecipeStage) compile(label string, checkpoint *Checkpoint) (Stage[string, string], error) {
+	if s.Combine {
+		if len(s.Parts) > 0 {
+			return nil, fmt.Errorf("combine stage has parts")
+		}
+		return combine(s.Join), nil
+	}
+
+	if len(s.Parts) == 0 {
+		return nil, fmt.Errorf("no parts")
+	}
+
+	parts := []func(string) string{}
+	for _, part := range s.Parts {
+		compiled, err := part.compile()
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, compiled...)
+	}
+
+	hash := checkpoint.Wrap(label, func(data string) string {
+		acc := make([]string, len(parts))
+		wg := &sync.WaitGroup{}
+		wg.Add(len(parts))
+		for i, part := range parts {
+			go func(i int, part func(string) string) {
+				defer wg.Done()
+				acc[i] = part(data)
+			}(i, part)
+		}
+		wg.Wait()
+		return strings.Join(acc, s.Join)
+	})
+
+	if s.Ordered {
+		if s.Workers < 1 {
+			return nil, fmt.Errorf("ordered stage needs workers")
+		}
+		return Ordered(s.Workers, hash), nil
+	}
+	if s.Workers > 0 {
+		return Pool(s.Workers, hash), nil
+	}
+	return Each(hash), nil
+}
+
+// compile returns a function per prefix of the part.
+func (p RecipePart) compile() ([]func(string) string, error) {
+	if len(p.Hash) == 0 {
+		return nil, fmt.Errorf("part has no hash")
+	}
+	if p.Prefix != "" && len(p.Prefixes) > 0 {
+		return nil, fmt.Errorf("part has both prefix and prefixes")
+	}
+
+	chain := make([]func(string) string, len(p.Hash))
+	for i, name := range p.Hash {
+		algorithm, ok := Algorithms[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown algorithm %q", name)
+		}
+		chain[i] = algorithm
+	}
+
+	prefixes := p.Prefixes
+	if len(prefixes) == 0 {
+		prefixes = []string{p.Prefix}
+	}
+
+	parts := make([]func(string) string, len(prefixes))
+	for i, prefix := range prefixes {
+		prefix := prefix
+		parts[i] = func(data string) string {
+			data = prefix + data
+			for _, algorithm := range chain {
+				data = algorithm(data)
+			}
+			return data
+		}
+	}
+	return parts, nil
+}
+
+// combine sorts all the items and joins them with sep.
+func combine(sep string) Stage[string, string] {
+	return func(ctx context.Context, in chan string, out chan string) error {
+		acc := []string{}
+		for {
+			inputData, ok, err := receive(ctx, in)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			acc = append(acc, inputData)
+		}
+
+		sort.Strings(acc)
+
+		return send(ctx, out, strings.Join(acc, sep))
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDefaultRecipe(t *testing.T) {
+	fastSigners(t)
+	expected := "29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542"
+
+	stages, err := DefaultRecipe.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := []string{}
+	stages = append([]StageConfig{{Job: source(0, 1)}}, stages...)
+	stages = append(stages, StageConfig{Job: collect(&result)})
+	if err := ExecutePipelineConfig(context.Background(), stages...); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != 1 || result[0] != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+
+	stage, err := DefaultRecipe.Stage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	typed, err := Run(context.Background(), stage, []string{"0", "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(typed) != 1 || typed[0] != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", typed, expected)
+	}
+}
+
+func TestParseRecipe(t *testing.T) {
+	yamlRecipe := `
+stages:
+  - name: SingleHash
+    join: "~"
+    parts:
+      - hash: [crc32]
+      - hash: [md5, crc32]
+  - name: MultiHash
+    parts:
+      - prefixes: ["0", "1", "2", "3", "4", "5"]
+        hash: [crc32]
+  - name: CombineResults
+    join: _
+    combine: true
+`
+	jsonRecipe := `{"stages": [
+		{"name": "SingleHash", "join": "~", "parts": [{"hash": ["crc32"]}, {"hash": ["md5", "crc32"]}]},
+		{"name": "MultiHash", "parts": [{"prefixes": ["0", "1", "2", "3", "4", "5"], "hash": ["crc32"]}]},
+		{"name": "CombineResults", "join": "_", "combine": true}
+	]}`
+
+	for _, text := range []string{yamlRecipe, jsonRecipe} {
+		recipe, err := ParseRecipe([]byte(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(recipe, DefaultRecipe) {
+			t.Errorf("recipe not match\nGot: %+v\nExpected: %+v", recipe, DefaultRecipe)
+		}
+	}
+}
+
+func TestRecipeAlgorithms(t *testing.T) {
+	recipe, err := ParseRecipe([]byte(`
+stages:
+  - join: " "
+    workers: 2
+    parts:
+      - hash: [sha256]
+      - hash: [blake2b]
+      - prefix: a
+        hash: [sha256, blake2b]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stage, err := recipe.Stage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Run(context.Background(), stage, []string{"bc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sha := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	expected := []string{
+		Algorithms["sha256"]("bc"),
+		Algorithms["blake2b"]("bc"),
+		Algorithms["blake2b"](sha),
+	}
+	if len(result) != 1 || result[0] != strings.Join(expected, " ") {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+	if blake := Algorithms["blake2b"]("abc"); blake != "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319" {
+		t.Errorf("unexpected blake2b %s", blake)
+	}
+}
+
+func TestRecipeErrors(t *testing.T) {
+	cases := map[string]string{
+		"stages: []":                                        "recipe: no stages",
+		"stages: [{name: x}]":                               "recipe: stage x: no parts",
+		"stages: [{parts: [{hash: [sha1]}]}]":               `recipe: stage 0: unknown algorithm "sha1"`,
+		"stages: [{combine: true, parts: [{hash: [md5]}]}]": "recipe: stage 0: combine stage has parts",
+		"stages: [{name: x, parts: [{prefix: a, prefixes: [b], hash: [md5]}]}]": "recipe: stage x: part has both prefix and prefixes",
+		"stages: [{name: x, ordered: true, parts: [{hash: [md5]}]}]":            "recipe: stage x: ordered stage needs workers",
+	}
+
+	for text, expected := range cases {
+		recipe, err := ParseRecipe([]byte(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = recipe.Compile()
+		if err == nil || err.Error() != expected {
+			t.Errorf("%s: expected %q, got %v", text, expected, err)
+		}
+	}
+
+	_, err := ParseRecipe([]byte("stages: [{name: x, algorithm: md5}]"))
+	if err == nil || !strings.Contains(err.Error(), "field algorithm not found") {
+		t.Errorf("unknown field not reported: %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Signer is a signing call that may fail or be given up on.
+type Signer func(ctx context.Context, data string) (string, error)
+
+// reliable adapts a signer that never fails.
+func reliable(signer func(string) string) Signer {
+	return func(ctx context.Context, data string) (string, error) {
+		return signer(data), nil
+	}
+}
+
+// Call adapts a plain signer, like DataSignerCrc32, so that the caller can
+// give up on it once ctx is done. The signer runs in a goroutine of its
+// own, which is left behind until the signer returns; a panic in it is
+// returned as an error.
+func Call(signer func(string) string) Signer {
+	return func(ctx context.Context, data string) (string, error) {
+		type result struct {
+			hash string
+			err  error
+		}
+		done := make(chan result, 1)
+
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					done <- result{err: fmt.Errorf("signer panic: %v", r)}
+				}
+			}()
+			done <- result{hash: signer(data)}
+		}()
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case r := <-done:
+			return r.hash, r.err
+		}
+	}
+}
+
+// RetryPolicy says how often and how long to try a signer call. Attempts
+// below 1 mean a single attempt and a zero Timeout means no timeout. The
+// wait before the n-th retry is Backoff*2^(n-1), at most MaxBackoff if it
+// is set, shortened by a random part of up to Jitter (0..1) of it so that
+// failed calls are not all retried at once.
+type RetryPolicy struct {
+	Attempts   int
+	Timeout    time.Duration
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Jitter     float64
+}
+
+func (p RetryPolicy) delay(retry int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < retry && (p.MaxBackoff == 0 || delay < p.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		delay -= time.Duration(p.Jitter * rand.Float64() * float64(delay))
+	}
+	return delay
+}
+
+// Retry calls signer until it succeeds, at most policy.Attempts times.
+func Retry(policy RetryPolicy, signer Signer) Signer {
+	return retry(policy, nil, signer)
+}
+
+// RetryLimited is Retry(policy, Call(signer)) for a signer under limiter.
+// Every attempt waits for a slot with ctx before its Timeout starts, so the
+// timeout covers the signer only. The slot is held until the signer
+// returns, also when the attempt has timed out by then.
+func RetryLimited(policy RetryPolicy, limiter Limiter, signer func(string) string) Signer {
+	if limiter == nil {
+		return Retry(policy, Call(signer))
+	}
+	return retry(policy, limiter, Call(func(data string) string {
+		defer limiter.Release()
+		return signer(data)
+	}))
+}
+
+// retry is Retry that acquires limiter before every attempt, signer
+// releases it.
+func retry(policy RetryPolicy, limiter Limiter, signer Signer) Signer {
+	return func(ctx context.Context, data string) (string, error) {
+		for attempt := 1; ; attempt++ {
+			if limiter != nil {
+				err := limiter.Acquire(ctx)
+				if err != nil {
+					return "", err
+				}
+			}
+
+			callCtx, cancel := ctx, context.CancelFunc(func() {})
+			if policy.Timeout > 0 {
+				callCtx, cancel = context.WithTimeout(ctx, policy.Timeout)
+			}
+			hash, err := signer(callCtx, data)
+			cancel()
+
+			if err == nil {
+				return hash, nil
+			}
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			if attempt >= policy.Attempts {
+				return "", fmt.Errorf("%d attempts failed, last: %v", attempt, err)
+			}
+
+			timer := time.NewTimer(policy.delay(attempt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return "", ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+// DeadLetter is an item a stage gave up on.
+type DeadLetter struct {
+	Stage string
+	Data  interface{}
+	Err   error
+}
+
+// Retrying is a stage that runs f on every item in a goroutine of its own,
+// like Each. An item f fails on is sent to deadLetters and the stage goes
+// on; with nil deadLetters it fails the stage. deadLetters must be read
+// for the stage to make progress.
+func Retrying[In, Out any](name string, f func(context.Context, In) (Out, error), deadLetters chan<- DeadLetter) Stage[In, Out] {
+	return func(ctx context.Context, in chan In, out chan Out) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		wg := &sync.WaitGroup{}
+		errs := &firstError{}
+
+		for {
+			data, ok, err := receive(ctx, in)
+			if err != nil || !ok {
+				wg.Wait()
+				if errs.err != nil {
+					return errs.err
+				}
+				return err
+			}
+
+			wg.Add(1)
+			go func(data In) {
+				defer wg.Done()
+				result, err := f(ctx, data)
+				switch {
+				case err == nil:
+					send(ctx, out, result)
+				case ctx.Err() != nil:
+				case deadLetters == nil:
+					errs.set(fmt.Errorf("%s: %v", name, err))
+					cancel()
+				default:
+					select {
+					case <-ctx.Done():
+					case deadLetters <- DeadLetter{Stage: name, Data: data, Err: err}:
+					}
+				}
+			}(data)
+		}
+	}
+}
+
+// SingleHashRetry is SingleHashStage that calls the signers with policy.
+// Items that fail are sent to deadLetters, see Retrying.
+func SingleHashRetry(policy RetryPolicy, deadLetters chan<- DeadLetter) Stage[string, string] {
+	return Retrying("SingleHash", func(ctx context.Context, data string) (string, error) {
+		md5 := MemoizeSigner("md5", RetryLimited(policy, Md5Limiter, DataSignerMd5), Md5Cache)
+		return trySingleHash(ctx, md5, crc32Retry(policy), data)
+	}, deadLetters)
+}
+
+// MultiHashRetry is MultiHashStage that calls the signer with policy.
+// Items that fail are sent to deadLetters, see Retrying.
+func MultiHashRetry(policy RetryPolicy, deadLetters chan<- DeadLetter) Stage[string, string] {
+	return Retrying("MultiHash", func(ctx context.Context, data string) (string, error) {
+		return tryMultiHash(ctx, crc32Retry(policy), data)
+	}, deadLetters)
+}
+
+func crc32Retry(policy RetryPolicy) Signer {
+	return MemoizeSigner("crc32", RetryLimited(policy, Crc32Limiter, DataSignerCrc32), Crc32Cache)
+}
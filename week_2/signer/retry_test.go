@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 30 * time.Millisecond}
+	delays := []time.Duration{}
+	for retry := 1; retry <= 4; retry++ {
+		delays = append(delays, policy.delay(retry))
+	}
+	expected := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond, 30 * time.Millisecond}
+	for i := range expected {
+		if delays[i] != expected[i] {
+			t.Fatalf("delays not match\nGot: %v\nExpected: %v", delays, expected)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if delay := policy.delay(2); delay < 10*time.Millisecond || delay > 20*time.Millisecond {
+			t.Fatalf("delay with jitter %s", delay)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var calls int32
+	flaky := func(ctx context.Context, data string) (string, error) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			return "", errors.New("busy")
+		}
+		return data + "!", nil
+	}
+
+	policy := RetryPolicy{Attempts: 3, Backoff: 5 * time.Millisecond}
+	start := time.Now()
+	hash, err := Retry(policy, flaky)(context.Background(), "x")
+	if err != nil || hash != "x!" {
+		t.Fatalf("unexpected result %q, %v", hash, err)
+	}
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("no backoff between attempts, took %s", elapsed)
+	}
+
+	calls = 0
+	policy.Attempts = 2
+	_, err = Retry(policy, flaky)(context.Background(), "x")
+	if err == nil || err.Error() != "2 attempts failed, last: busy" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestRetryTimeout(t *testing.T) {
+	before := time.Now()
+	hang := Call(func(data string) string {
+		time.Sleep(200 * time.Millisecond)
+		return data
+	})
+
+	policy := RetryPolicy{Attempts: 2, Timeout: 10 * time.Millisecond}
+	_, err := Retry(policy, hang)(context.Background(), "x")
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if elapsed := time.Since(before); elapsed > 100*time.Millisecond {
+		t.Errorf("hanging signer was waited for %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Retry(RetryPolicy{Attempts: 5}, hang)(ctx, "x"); err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+
+	_, err = Call(func(string) string { panic("boom") })(context.Background(), "x")
+	if err == nil || err.Error() != "signer panic: boom" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestHashRetryDeadLetters(t *testing.T) {
+	fastSigners(t)
+	crc32Signer := DataSignerCrc32
+	var failures int32
+	DataSignerCrc32 = func(data string) string {
+		if data == "bad" || (data == "0" && atomic.AddInt32(&failures, 1) == 1) {
+			panic("backend is down")
+		}
+		return crc32Signer(data)
+	}
+
+	deadLetters := make(chan DeadLetter, 10)
+	policy := RetryPolicy{Attempts: 2, Backoff: time.Millisecond}
+	signer := Pipe(SingleHashRetry(policy, deadLetters), MultiHashRetry(policy, deadLetters))
+
+	result, err := Run(context.Background(), signer, []string{"0", "bad", "1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	close(deadLetters)
+
+	sort.Strings(result)
+	expected := "29568666068035183841425683795340791879727309630931025356555 4958044192186797981418233587017209679042592862002427381542"
+	if got := strings.Join(result, " "); got != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", got, expected)
+	}
+
+	letters := []DeadLetter{}
+	for letter := range deadLetters {
+		letters = append(letters, letter)
+	}
+	if len(letters) != 1 || letters[0].Stage != "SingleHash" || letters[0].Data != "bad" ||
+		letters[0].Err.Error() != "2 attempts failed, last: signer panic: backend is down" {
+		t.Errorf("unexpected dead letters %+v", letters)
+	}
+
+	_, err = Run(context.Background(), SingleHashRetry(policy, nil), []string{"0", "bad"})
+	if err == nil || !strings.HasPrefix(err.Error(), "SingleHash: 2 attempts failed") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestHashRetryMd5Limiter(t *testing.T) {
+	fastSigners(t)
+	md5Signer := DataSignerMd5
+	DataSignerMd5 = func(data string) string {
+		time.Sleep(20 * time.Millisecond)
+		return md5Signer(data)
+	}
+
+	items := []string{}
+	for i := 0; i < 20; i++ {
+		items = append(items, strconv.Itoa(i))
+	}
+
+	// the items wait up to 400ms for the only MD5 slot, the timeout is for
+	// the 20ms call
+	deadLetters := make(chan DeadLetter, len(items))
+	policy := RetryPolicy{Attempts: 1, Timeout: 100 * time.Millisecond}
+	result, err := Run(context.Background(), SingleHashRetry(policy, deadLetters), items)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	close(deadLetters)
+
+	for letter := range deadLetters {
+		t.Errorf("unexpected dead letter %+v", letter)
+	}
+	if len(result) != len(items) {
+		t.Errorf("%d of %d items hashed", len(result), len(items))
+	}
+}
+
+func TestHashRetryCacheSkipsLimiter(t *testing.T) {
+	fastSigners(t)
+	md5Cache, crc32Cache, md5Limiter := Md5Cache, Crc32Cache, Md5Limiter
+	defer func() {
+		Md5Cache, Crc32Cache, Md5Limiter = md5Cache, crc32Cache, md5Limiter
+	}()
+	Md5Cache, Crc32Cache, Md5Limiter = NewCache(100), NewCache(100), NewSemaphore(1)
+
+	items := []string{"0", "1"}
+	policy := RetryPolicy{Attempts: 1, Timeout: time.Second}
+	cold, err := Run(context.Background(), SingleHashRetry(policy, nil), items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acquired := Md5Limiter.Stats().Acquired
+	if acquired != 2 {
+		t.Errorf("md5 limiter acquired %d times for 2 items", acquired)
+	}
+
+	warm, err := Run(context.Background(), SingleHashRetry(policy, nil), items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := Md5Limiter.Stats().Acquired; n != acquired {
+		t.Errorf("md5 limiter acquired %d more times with a warm cache", n-acquired)
+	}
+	if stats := Md5Cache.Stats(); stats.Hits != 2 {
+		t.Errorf("unexpected md5 cache stats %+v", stats)
+	}
+	sort.Strings(cold)
+	sort.Strings(warm)
+	if !reflect.DeepEqual(cold, warm) {
+		t.Errorf("cached results %v differ from %v", warm, cold)
+	}
+}
@@ -1,13 +1,23 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
 )
 
+// Md5Limiter and Crc32Limiter are the quotas the hashing stages apply to
+// DataSignerMd5 and DataSignerCrc32 calls, nil means no limit. Md5Limiter
+// sits in front of OverheatLock: with the default quota of one call the
+// lock is always free, a bigger quota lets calls through that then wait on
+// the lock unless OverheatLock is replaced.
+var (
+	Md5Limiter   Limiter = NewSemaphore(1)
+	Crc32Limiter Limiter
+)
+
 func ExecutePipeline(workers ...job) {
 	wg := &sync.WaitGroup{}
 	in := make(chan interface{})
@@ -43,93 +53,177 @@ func SelectedType(i interface{}) (string, error) {
 }
 
 func SingleHash(in, out chan interface{}) {
-	wg := &sync.WaitGroup{}
-	mu := &sync.Mutex{}
-	for inputData := range in {
-		dataCast, err := SelectedType(inputData)
+	mustRun(SingleHashContext, in, out)
+}
 
-		if err != nil {
-			panic("Data must be int or string")
-		}
-		wg.Add(1)
-		go jobSingleHash(dataCast, out, wg, mu)
-	}
-	wg.Wait()
+// SingleHashContext is SingleHash that returns an error on bad input and
+// stops taking new items once ctx is cancelled.
+func SingleHashContext(ctx context.Context, in, out chan interface{}) error {
+	return untypedHash("SingleHash", SingleHashStage)(ctx, in, out)
+}
+
+// SingleHashStage is the typed SingleHash.
+func SingleHashStage(ctx context.Context, in chan string, out chan string) error {
+	return Each(singleHash)(ctx, in, out)
+}
+
+// singleHash computes crc32(data)~crc32(md5(data)).
+func singleHash(inputData string) string {
+	hash, _ := trySingleHash(context.Background(), reliable(md5Signer()), reliable(crc32Signer()), inputData)
+	return hash
 }
 
-func jobSingleHash(inputData string, out chan interface{}, wg *sync.WaitGroup, mu *sync.Mutex) {
-	defer wg.Done()
-	var crcDataOne string
-	var crcDataSecond string
-	mu.Lock()
-	md5Data := DataSignerMd5(inputData)
-	mu.Unlock()
+// trySingleHash is singleHash with signers that may fail, the first
+// error stops the other calls.
+func trySingleHash(ctx context.Context, md5, crc32 Signer, inputData string) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	crc32Chan := make(chan string)
-	crc32Md5Chan := make(chan string)
+	errs := &firstError{}
+	fail := func(err error) {
+		errs.set(err)
+		cancel()
+	}
+	crc32Chan := make(chan string, 1)
 
 	go func(data string, out chan string) {
-		out <- DataSignerCrc32(data)
+		hash, err := crc32(ctx, data)
+		if err != nil {
+			fail(err)
+		}
+		out <- hash
 	}(inputData, crc32Chan)
 
-	go func(md5Data string, out chan string) {
-		out <- DataSignerCrc32(md5Data)
-	}(md5Data, crc32Md5Chan)
-	crcDataOne = <-crc32Chan
-	crcDataSecond = <-crc32Md5Chan
-	result := crcDataOne + "~" + crcDataSecond
+	crcDataSecond, err := md5(ctx, inputData)
+	if err == nil {
+		crcDataSecond, err = crc32(ctx, crcDataSecond)
+	}
+	if err != nil {
+		fail(err)
+	}
+	crcDataOne := <-crc32Chan
+
+	if errs.err != nil {
+		return "", errs.err
+	}
+	return crcDataOne + "~" + crcDataSecond, nil
+}
+
+// SingleHashOrdered is SingleHashStage that emits hashes in input order
+// with at most window items hashed or waiting for their turn at once.
+func SingleHashOrdered(window int) Stage[string, string] {
+	return Ordered(window, singleHash)
+}
 
-	out <- result
+// SingleHashPool is SingleHashContext that hashes at most workers items at
+// once instead of starting a goroutine for every item.
+func SingleHashPool(workers int) ctxJob {
+	return untypedHash("SingleHash", Pool(workers, singleHash))
 }
 
 func MultiHash(in, out chan interface{}) {
-	wg := &sync.WaitGroup{}
-	for inputData := range in {
-		dataCast, err := SelectedType(inputData)
+	mustRun(MultiHashContext, in, out)
+}
 
-		if err != nil {
-			panic("Data must be int or string")
-		}
-		wg.Add(1)
-		go jobMultiHash(dataCast, out, wg)
-	}
-	wg.Wait()
+// MultiHashContext is MultiHash that returns an error on bad input and
+// stops taking new items once ctx is cancelled.
+func MultiHashContext(ctx context.Context, in, out chan interface{}) error {
+	return untypedHash("MultiHash", MultiHashStage)(ctx, in, out)
+}
+
+// MultiHashStage is the typed MultiHash.
+func MultiHashStage(ctx context.Context, in chan string, out chan string) error {
+	return Each(multiHash)(ctx, in, out)
 }
 
-func jobMultiHash(data string, out chan interface{}, wg *sync.WaitGroup) {
-	defer wg.Done()
+// multiHash concatenates crc32(th+data) for th 0..5.
+func multiHash(data string) string {
+	hash, _ := tryMultiHash(context.Background(), reliable(crc32Signer()), data)
+	return hash
+}
+
+// tryMultiHash is multiHash with a signer that may fail, the first error
+// stops the other calls.
+func tryMultiHash(ctx context.Context, crc32 Signer, data string) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	th := []int{0, 1, 2, 3, 4, 5}
 	lengthTh := len(th)
 	acc := make([]string, lengthTh, lengthTh)
 	wgJob := &sync.WaitGroup{}
-	mu := &sync.Mutex{}
+	errs := &firstError{}
 	wgJob.Add(lengthTh)
 
 	for i, val := range th {
-		go func(val int, index int, wgJob *sync.WaitGroup, mu *sync.Mutex) {
+		go func(val int, index int) {
 			defer wgJob.Done()
-			hash := DataSignerCrc32(strconv.Itoa(val) + data)
-			mu.Lock()
+			hash, err := crc32(ctx, strconv.Itoa(val)+data)
+			if err != nil {
+				errs.set(err)
+				cancel()
+			}
 			acc[index] = hash
-			mu.Unlock()
-		}(val, i, wgJob, mu)
+		}(val, i)
 	}
 	wgJob.Wait()
-	out <- strings.Join(acc, "")
+	if errs.err != nil {
+		return "", errs.err
+	}
+	return strings.Join(acc, ""), nil
+}
+
+// MultiHashOrdered is MultiHashStage that emits hashes in input order with
+// at most window items hashed or waiting for their turn at once.
+func MultiHashOrdered(window int) Stage[string, string] {
+	return Ordered(window, multiHash)
+}
+
+// MultiHashPool is MultiHashContext that hashes at most workers items at
+// once, which is at most 6*workers DataSignerCrc32 calls.
+func MultiHashPool(workers int) ctxJob {
+	return untypedHash("MultiHash", Pool(workers, multiHash))
 }
 
 func CombineResults(in, out chan interface{}) {
-	acc := []string{}
-	for inputData := range in {
+	mustRun(CombineResultsContext, in, out)
+}
 
-		dataCast, err := SelectedType(inputData)
-		if err != nil {
-			panic("Data must be int or string")
+// CombineResultsContext is CombineResults that returns an error on bad
+// input and gives up once ctx is cancelled.
+func CombineResultsContext(ctx context.Context, in, out chan interface{}) error {
+	return untypedHash("CombineResults", CombineResultsStage)(ctx, in, out)
+}
+
+// CombineResultsStage is the typed CombineResults.
+func CombineResultsStage(ctx context.Context, in chan string, out chan string) error {
+	return combine("_")(ctx, in, out)
+}
+
+// untypedHash accepts ints and strings like the plain stages do.
+func untypedHash(name string, stage Stage[string, string]) ctxJob {
+	toData := func(data interface{}) (string, error) {
+		// not SelectedType, which prints on bad data
+		switch data := data.(type) {
+		case int:
+			return strconv.Itoa(data), nil
+		case string:
+			return data, nil
 		}
-		acc = append(acc, dataCast)
+		return "", fmt.Errorf("%s: %v", name, errBadData(data))
 	}
+	return Pipe(Pipe(Map(toData), stage), Map(boxType[string]))
+}
 
-	sort.Strings(acc)
+func errBadData(data interface{}) error {
+	return fmt.Errorf("data must be int or string, got %T", data)
+}
 
-	out <- strings.Join(acc, "_")
+// mustRun keeps the old behaviour of the plain stages: they panic on bad
+// input.
+func mustRun(stage ctxJob, in, out chan interface{}) {
+	err := stage(context.Background(), in, out)
+	if err != nil {
+		panic(err.Error())
+	}
 }
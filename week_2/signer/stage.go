@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Stage is a typed pipeline step. Stages are connected with Pipe, so the
+// compiler checks that each one takes what the previous one produces.
+type Stage[In, Out any] func(ctx context.Context, in chan In, out chan Out) error
+
+// Pipe runs first and second connected by a channel as a single stage. An
+// error in either one cancels the other and is returned.
+func Pipe[A, B, C any](first Stage[A, B], second Stage[B, C]) Stage[A, C] {
+	return func(ctx context.Context, in chan A, out chan C) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		wg := &sync.WaitGroup{}
+		errs := &firstError{}
+		mid := make(chan B)
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			defer close(mid)
+			err := runStage(ctx, first, in, mid)
+			if err != nil {
+				errs.set(err)
+				cancel()
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			defer drain(mid)
+			err := runStage(ctx, second, mid, out)
+			if err != nil {
+				errs.set(err)
+				cancel()
+			}
+		}()
+
+		wg.Wait()
+
+		return errs.err
+	}
+}
+
+// Map is a stage that applies f to every item in order.
+func Map[In, Out any](f func(In) (Out, error)) Stage[In, Out] {
+	return func(ctx context.Context, in chan In, out chan Out) error {
+		for {
+			data, ok, err := receive(ctx, in)
+			if err != nil || !ok {
+				return err
+			}
+
+			result, err := f(data)
+			if err != nil {
+				return err
+			}
+
+			err = send(ctx, out, result)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Run sends items through stage and collects what comes out.
+func Run[In, Out any](parent context.Context, stage Stage[In, Out], items []In) ([]Out, error) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	in := make(chan In)
+	out := make(chan Out)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(in)
+		for _, item := range items {
+			if send(ctx, in, item) != nil {
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer close(out)
+		errc <- runStage(ctx, stage, in, out)
+	}()
+
+	results := []Out{}
+	for result := range out {
+		results = append(results, result)
+	}
+	cancel()
+
+	err := <-errc
+	if err == nil {
+		err = parent.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Untyped adapts s to ExecutePipelineContext, an item of another type than
+// In fails the stage.
+func (s Stage[In, Out]) Untyped() ctxJob {
+	return Pipe(Pipe(Map(assertType[In]), s), Map(boxType[Out]))
+}
+
+// Job adapts s to ExecutePipeline, it panics on errors like the plain
+// stages do.
+func (s Stage[In, Out]) Job() job {
+	untyped := s.Untyped()
+	return func(in, out chan interface{}) {
+		mustRun(untyped, in, out)
+	}
+}
+
+// FromJob adapts a plain job to a typed pipeline. An output item of
+// another type than Out fails the stage.
+func FromJob[In, Out any](worker job) Stage[In, Out] {
+	return Pipe(Pipe(Map(boxType[In]), withContext(worker)), Map(assertType[Out]))
+}
+
+func assertType[T any](data interface{}) (T, error) {
+	typed, ok := data.(T)
+	if !ok {
+		return typed, fmt.Errorf("unexpected %T, want %T", data, typed)
+	}
+	return typed, nil
+}
+
+func boxType[T any](data T) (interface{}, error) {
+	return data, nil
+}
+
+// runStage turns a panic inside the stage into an error.
+func runStage[In, Out any](ctx context.Context, stage Stage[In, Out], in chan In, out chan Out) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("stage panic: %v", r)
+		}
+	}()
+	return stage(ctx, in, out)
+}
+
+func drain[T any](in chan T) {
+	for range in {
+	}
+}
+
+// receive returns the next item of in, ok is false when in is closed.
+func receive[T any](ctx context.Context, in chan T) (T, bool, error) {
+	select {
+	case <-ctx.Done():
+		var zero T
+		return zero, false, ctx.Err()
+	case data, ok := <-in:
+		return data, ok, nil
+	}
+}
+
+func send[T any](ctx context.Context, out chan T, data T) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case out <- data:
+		return nil
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func itoa(n int) (string, error) {
+	return strconv.Itoa(n), nil
+}
+
+func TestStageSigner(t *testing.T) {
+	fastSigners(t)
+
+	signer := Pipe(Pipe(Pipe(Map(itoa), SingleHashStage), MultiHashStage), CombineResultsStage)
+
+	result, err := Run(context.Background(), signer, []int{0, 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542"
+	if len(result) != 1 || result[0] != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+}
+
+func TestStageJobAdapters(t *testing.T) {
+	fastSigners(t)
+
+	var result string
+	ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			out <- 0
+			out <- 1
+		}),
+		Map(func(data interface{}) (string, error) {
+			return strconv.Itoa(data.(int)), nil
+		}).Job(),
+		Stage[string, string](SingleHashStage).Job(),
+		job(MultiHash),
+		Stage[string, string](CombineResultsStage).Job(),
+		job(func(in, out chan interface{}) {
+			result = (<-in).(string)
+		}),
+	)
+
+	expected := "29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542"
+	if result != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+
+	typed := Pipe(Pipe(Map(itoa), FromJob[string, string](SingleHash)), FromJob[string, string](MultiHash))
+	hashes, err := Run(context.Background(), typed, []int{0})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(hashes) != 1 || hashes[0] != "29568666068035183841425683795340791879727309630931025356555" {
+		t.Errorf("unexpected hashes %v", hashes)
+	}
+}
+
+func TestStageTypeMismatch(t *testing.T) {
+	err := ExecutePipelineContext(context.Background(),
+		source("1", 2),
+		Map(func(s string) (string, error) { return s, nil }).Untyped(),
+	)
+	if err == nil || !strings.Contains(err.Error(), "unexpected int, want string") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	_, err = Run(context.Background(), FromJob[int, int](func(in, out chan interface{}) {
+		for data := range in {
+			out <- strconv.Itoa(data.(int))
+		}
+	}), []int{1})
+	if err == nil || !strings.Contains(err.Error(), "unexpected string, want int") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -1,15 +1,11 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
-	"slices"
-	"strconv"
-	"strings"
 
 	easyjson "github.com/mailru/easyjson"
 	jlexer "github.com/mailru/easyjson/jlexer"
@@ -144,94 +140,61 @@ func (v *User) UnmarshalEasyJSON(l *jlexer.Lexer) {
 }
 
 const (
-	android = "Android"
-	msie    = "MSIE"
-	// filePath = "./data/users.txt"
+	fastQuery    = `browsers contains "Android" and browsers contains "MSIE"`
+	fastTemplate = "[{index}] {name} <{email|at}>"
 )
 
-func FastSearch(out io.Writer) {
-	f, err := os.Open("./data/users.txt")
+var fastSearcher = mustSearcher(fastQuery, fastTemplate)
 
+func mustSearcher(query string, template string) *Searcher {
+	s, err := NewSearcher(query, template)
 	if err != nil {
 		panic(err)
 	}
+	return s
+}
 
-	defer f.Close()
-
-	seenBrowsers := []string{}
-	index := 0
-	scanner := bufio.NewScanner(f)
-	user := &User{}
-	formatedEmail := ""
-
-	out.Write([]byte("found users:\n"))
-
-	for scanner.Scan() {
-		byteText := scanner.Bytes()
-		if !(strings.Contains(string(byteText), android) || strings.Contains(string(byteText), msie)) {
-			index += 1
-			continue
-		}
-
-		err := user.UnmarshalJSON(byteText)
-		if err != nil {
-			panic(err)
-		}
-
-		isAndroid := false
-		isMSIE := false
-
-		for _, browser := range user.Browsers {
-
-			if strings.Contains(browser, android) {
-				isAndroid = true
-				if !slices.Contains(seenBrowsers, browser) {
-					seenBrowsers = append(seenBrowsers, browser)
-				}
-			}
-
-			if strings.Contains(browser, msie) {
-				isMSIE = true
-				if !slices.Contains(seenBrowsers, browser) {
-					seenBrowsers = append(seenBrowsers, browser)
-				}
-			}
-		}
-
-		if !(isAndroid && isMSIE) {
-			index += 1
-			continue
-		}
-
-		buf := bytes.Buffer{}
+func FastSearch(out io.Writer) {
+	FastSearchFile(out, filePath)
+}
 
-		formatedEmail = strings.Replace(user.Email, "@", " [at] ", -1)
+// FastSearchFile is FastSearch over the file at path, which may be gzip or
+// zstd compressed, "-" is stdin.
+func FastSearchFile(out io.Writer, path string) {
+	fastSearch(out, func() (SearchStats, error) {
+		return fastSearcher.SearchFile(path, out)
+	})
+}
 
-		// formatedUser := fmt.Sprintf("[%d] %s <%s>\n", index, user.Name, email)
+// FastSearchParallel is FastSearch that decodes the file on workers
+// goroutines, 0 means GOMAXPROCS.
+func FastSearchParallel(out io.Writer, workers int) {
+	fastSearch(out, func() (SearchStats, error) {
+		return fastSearcher.SearchFileParallel(filePath, out, workers)
+	})
+}
 
-		// out.Write([]byte(formatedUser))
+func fastSearch(out io.Writer, search func() (SearchStats, error)) {
+	out.Write([]byte("found users:\n"))
 
-		buf.WriteByte('[')
-		buf.WriteString(strconv.Itoa(index))
-		buf.WriteByte(']')
-		buf.WriteByte(' ')
-		buf.WriteString(user.Name)
-		buf.WriteByte(' ')
-		buf.WriteByte('<')
-		buf.WriteString(formatedEmail)
-		buf.WriteByte('>')
-		buf.WriteByte('\n')
-		out.Write(buf.Bytes())
-		index += 1
+	stats, err := search()
+	if err != nil {
+		panic(err)
 	}
+
 	out.Write([]byte("\n"))
 
-	fmt.Fprintln(out, "Total unique browsers", len(seenBrowsers))
+	fmt.Fprintln(out, "Total unique browsers", stats.UniqueBrowsers)
 }
 
 func main() {
+	path := filePath
+	if len(os.Args) > 1 {
+		path = os.Args[1]
+	}
+
 	fastOut := new(bytes.Buffer)
-	FastSearch(fastOut)
+	FastSearchFile(fastOut, path)
 	fastResult := fastOut.String()
 	fmt.Println(fastResult)
 }
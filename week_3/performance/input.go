@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+type input struct {
+	io.Reader
+	close []func() error
+}
+
+func (in *input) Close() error {
+	var result error
+	for i := len(in.close) - 1; i >= 0; i-- {
+		if err := in.close[i](); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// OpenInput opens the file at path, "-" is stdin, and decompresses it if
+// it is gzip or zstd.
+func OpenInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return Decompress(os.Stdin)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	in, err := decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	in.close = append([]func() error{f.Close}, in.close...)
+	return in, nil
+}
+
+// Decompress detects gzip and zstd by the magic bytes at the start of r,
+// other input is read as is. Closing the result does not close r.
+func Decompress(r io.Reader) (io.ReadCloser, error) {
+	in, err := decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+func decompress(r io.Reader) (*input, error) {
+	buffered := bufio.NewReader(r)
+	// a short input is plain, its error is returned by the first read
+	magic, _ := buffered.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		decoder, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return &input{Reader: decoder, close: []func() error{decoder.Close}}, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		decoder, err := zstd.NewReader(buffered, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			return nil, err
+		}
+		return &input{Reader: decoder, close: []func() error{func() error {
+			decoder.Close()
+			return nil
+		}}}, nil
+	}
+	return &input{Reader: buffered}, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func compress(t testing.TB, format string, data []byte) []byte {
+	buf := new(bytes.Buffer)
+	var w io.WriteCloser
+	switch format {
+	case "plain":
+		return data
+	case "gzip":
+		w = gzip.NewWriter(buf)
+	case "zstd":
+		var err error
+		w, err = zstd.NewWriter(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// compressedInputs writes data/users.txt compressed with each format to dir.
+func compressedInputs(t testing.TB, dir string) map[string]string {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := map[string]string{}
+	for _, format := range []string{"plain", "gzip", "zstd"} {
+		paths[format] = filepath.Join(dir, "users."+format)
+		if err := os.WriteFile(paths[format], compress(t, format, data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return paths
+}
+
+func TestDecompress(t *testing.T) {
+	for _, data := range []string{searchUsers, "", "{", "\x1f"} {
+		for _, format := range []string{"plain", "gzip", "zstd"} {
+			in, err := Decompress(bytes.NewReader(compress(t, format, []byte(data))))
+			if err != nil {
+				t.Fatalf("%s %q: %v", format, data, err)
+			}
+			result, err := ioutil.ReadAll(in)
+			if err != nil || string(result) != data {
+				t.Errorf("%s: got %q, %v\nExpected: %q", format, result, err, data)
+			}
+			if err := in.Close(); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	if _, err := Decompress(bytes.NewReader([]byte{0x1f, 0x8b, 0, 0})); err == nil {
+		t.Errorf("broken gzip header is not reported")
+	}
+	in, _ := Decompress(bytes.NewReader(compress(t, "gzip", []byte(searchUsers))[:30]))
+	if _, err := ioutil.ReadAll(in); err == nil {
+		t.Errorf("truncated gzip is not reported")
+	}
+}
+
+func TestSearchCompressed(t *testing.T) {
+	expectedOut := new(bytes.Buffer)
+	FastSearch(expectedOut)
+	expected := expectedOut.String()
+
+	for format, path := range compressedInputs(t, t.TempDir()) {
+		out := new(bytes.Buffer)
+		FastSearchFile(out, path)
+		if out.String() != expected {
+			t.Errorf("%s: results not match\nGot:\n%v\nExpected:\n%v", format, out.String(), expected)
+		}
+
+		out.Reset()
+		fastSearch(out, func() (SearchStats, error) {
+			return fastSearcher.SearchFileParallel(path, out, 2)
+		})
+		if out.String() != expected {
+			t.Errorf("%s parallel: results not match\nGot:\n%v\nExpected:\n%v", format, out.String(), expected)
+		}
+	}
+}
+
+func TestSearchStdin(t *testing.T) {
+	expectedOut := new(bytes.Buffer)
+	FastSearch(expectedOut)
+
+	stdin := os.Stdin
+	defer func() { os.Stdin = stdin }()
+
+	for format, path := range compressedInputs(t, t.TempDir()) {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Stdin = f
+
+		out := new(bytes.Buffer)
+		FastSearchFile(out, "-")
+		f.Close()
+		if out.String() != expectedOut.String() {
+			t.Errorf("%s: results not match\nGot:\n%v\nExpected:\n%v", format, out.String(), expectedOut.String())
+		}
+	}
+}
+
+// go test -bench Input -benchmem
+func BenchmarkInput(b *testing.B) {
+	paths := compressedInputs(b, b.TempDir())
+	for _, format := range []string{"plain", "gzip", "zstd"} {
+		b.Run(format, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				FastSearchFile(ioutil.Discard, paths[format])
+			}
+		})
+	}
+}
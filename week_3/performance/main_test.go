@@ -30,6 +30,22 @@ func TestSearch(t *testing.T) {
 	}
 }
 
+func TestSearchParallel(t *testing.T) {
+	slowOut := new(bytes.Buffer)
+	SlowSearch(slowOut)
+	slowResult := slowOut.String()
+
+	for _, workers := range []int{1, 4} {
+		parallelOut := new(bytes.Buffer)
+		FastSearchParallel(parallelOut, workers)
+		parallelResult := parallelOut.String()
+
+		if slowResult != parallelResult {
+			t.Errorf("results not match with %d workers\nGot:\n%v\nExpected:\n%v", workers, parallelResult, slowResult)
+		}
+	}
+}
+
 // -----
 // go test -bench . -benchmem
 
@@ -44,3 +60,9 @@ func BenchmarkFast(b *testing.B) {
 		FastSearch(ioutil.Discard)
 	}
 }
+
+func BenchmarkFastParallel(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		FastSearchParallel(ioutil.Discard, 0)
+	}
+}
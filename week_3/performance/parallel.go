@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+const defaultChunkSize = 64 << 10
+
+type chunk struct {
+	seq  int
+	data []byte
+}
+
+type chunkMatch struct {
+	line int
+	user User
+}
+
+// chunkResult is what a worker found in a chunk, lines are numbered from
+// the start of the chunk.
+type chunkResult struct {
+	seq      int
+	lines    int
+	matches  []chunkMatch
+	browsers []string
+	err      error
+	errLine  int
+}
+
+// SearchParallel is Search that splits r into chunks of whole lines,
+// decodes them on workers goroutines and writes the results in line order,
+// so the output and stats are the same as of Search. At most 2*workers
+// chunks are read ahead of the output.
+func (s *Searcher) SearchParallel(r io.Reader, out io.Writer, workers int) (SearchStats, error) {
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	chunks := make(chan chunk)
+	results := make(chan chunkResult)
+	slots := make(chan struct{}, 2*workers)
+	done := make(chan struct{})
+	var readErr error
+
+	go func() {
+		defer close(chunks)
+		readErr = s.readChunks(r, chunks, slots, done)
+	}()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			s.searchChunks(chunks, results, done)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	stats := SearchStats{}
+	seen := map[string]struct{}{}
+	pending := map[int]chunkResult{}
+	next := 0
+	buf := []byte{}
+	var err error
+
+	for result := range results {
+		if err != nil {
+			continue
+		}
+		pending[result.seq] = result
+
+		for err == nil {
+			result, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+
+			buf, err = s.merge(result, &stats, seen, out, buf)
+			<-slots
+			if err != nil {
+				close(done)
+			}
+		}
+	}
+
+	// the reader is done once results are closed, unless it was stopped
+	if err == nil {
+		err = readErr
+	}
+	stats.UniqueBrowsers = len(seen)
+	return stats, err
+}
+
+func (s *Searcher) merge(result chunkResult, stats *SearchStats, seen map[string]struct{}, out io.Writer, buf []byte) ([]byte, error) {
+	for _, browser := range result.browsers {
+		seen[browser] = struct{}{}
+	}
+
+	for _, match := range result.matches {
+		stats.Matched++
+		buf = s.template.AppendTo(buf[:0], stats.Lines+match.line, &match.user)
+		buf = append(buf, '\n')
+		_, err := out.Write(buf)
+		if err != nil {
+			return buf, err
+		}
+	}
+
+	if result.err != nil {
+		stats.Lines += result.errLine
+		return buf, fmt.Errorf("line %d: %v", stats.Lines+1, result.err)
+	}
+	stats.Lines += result.lines
+	return buf, nil
+}
+
+// readChunks cuts r into chunks ending with a newline. A line longer than
+// the chunk size gets a chunk of its own.
+func (s *Searcher) readChunks(r io.Reader, chunks chan<- chunk, slots chan struct{}, done <-chan struct{}) error {
+	carry := []byte{}
+	for seq := 0; ; {
+		size := s.chunkSize
+		if len(carry) >= size {
+			size = 2 * len(carry)
+		}
+		buf := make([]byte, size)
+		n := copy(buf, carry)
+		read, err := io.ReadFull(r, buf[n:])
+		buf = buf[:n+read]
+
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			return err
+		}
+
+		data := buf
+		carry = nil
+		if !eof {
+			last := bytes.LastIndexByte(buf, '\n')
+			if last < 0 {
+				carry = buf
+				continue
+			}
+			data, carry = buf[:last+1], buf[last+1:]
+		}
+
+		if len(data) > 0 {
+			select {
+			case <-done:
+				return nil
+			case slots <- struct{}{}:
+			}
+			select {
+			case <-done:
+				return nil
+			case chunks <- chunk{seq: seq, data: data}:
+			}
+			seq++
+		}
+
+		if eof {
+			return nil
+		}
+	}
+}
+
+func (s *Searcher) searchChunks(chunks <-chan chunk, results chan<- chunkResult, done <-chan struct{}) {
+	user := &User{}
+	seen := map[string]struct{}{}
+	for c := range chunks {
+		select {
+		case <-done:
+			return
+		case results <- s.searchChunk(c, user, seen):
+		}
+	}
+}
+
+func (s *Searcher) searchChunk(c chunk, user *User, seen map[string]struct{}) chunkResult {
+	result := chunkResult{seq: c.seq}
+	data := c.data
+
+	for len(data) > 0 {
+		line := data
+		end := bytes.IndexByte(data, '\n')
+		if end >= 0 {
+			line, data = data[:end], data[end+1:]
+		} else {
+			data = nil
+		}
+		if len(line) > 0 && line[len(line)-1] == '\r' {
+			line = line[:len(line)-1]
+		}
+
+		matched, err := s.check(line, user, seen)
+		if err != nil {
+			result.err, result.errLine = err, result.lines
+			break
+		}
+		if matched {
+			result.matches = append(result.matches, chunkMatch{
+				line: result.lines,
+				user: User{Name: user.Name, Email: user.Email, Browsers: append([]string(nil), user.Browsers...)},
+			})
+		}
+		result.lines++
+	}
+
+	for browser := range seen {
+		result.browsers = append(result.browsers, browser)
+		delete(seen, browser)
+	}
+	return result
+}
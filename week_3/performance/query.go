@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Query is a compiled predicate over User fields, e.g.
+//
+//	browsers contains "Android" and browsers contains "MSIE"
+//	(name == "Sharon Crawford" or email contains ".edu") and not browsers contains "Opera"
+//
+// Fields are browsers, name and email, operators are contains, == and !=.
+// A condition on browsers holds if it holds for one of the browsers, != is
+// the negation of ==.
+type Query struct {
+	root       node
+	conditions []*condition
+	negated    bool
+}
+
+type node interface {
+	match(user *User) bool
+}
+
+type field int
+
+const (
+	fieldBrowsers field = iota
+	fieldName
+	fieldEmail
+)
+
+var fields = map[string]field{
+	"browsers": fieldBrowsers,
+	"name":     fieldName,
+	"email":    fieldEmail,
+}
+
+type condition struct {
+	field    field
+	contains bool
+	value    string
+}
+
+func (c *condition) test(s string) bool {
+	if c.contains {
+		return strings.Contains(s, c.value)
+	}
+	return s == c.value
+}
+
+func (c *condition) match(user *User) bool {
+	switch c.field {
+	case fieldName:
+		return c.test(user.Name)
+	case fieldEmail:
+		return c.test(user.Email)
+	}
+	for _, browser := range user.Browsers {
+		if c.test(browser) {
+			return true
+		}
+	}
+	return false
+}
+
+type notNode struct {
+	node node
+}
+
+func (n notNode) match(user *User) bool {
+	return !n.node.match(user)
+}
+
+type andNode []node
+
+func (n andNode) match(user *User) bool {
+	for _, child := range n {
+		if !child.match(user) {
+			return false
+		}
+	}
+	return true
+}
+
+type orNode []node
+
+func (n orNode) match(user *User) bool {
+	for _, child := range n {
+		if child.match(user) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *Query) Match(user *User) bool {
+	return q.root.match(user)
+}
+
+// browserConditions are the conditions on browsers, a browser one of them
+// holds for is counted as seen.
+func (q *Query) browserConditions() []*condition {
+	result := []*condition{}
+	for _, c := range q.conditions {
+		if c.field == fieldBrowsers {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+type token struct {
+	text   string
+	quoted bool
+	pos    int
+}
+
+func tokenize(expr string) ([]token, error) {
+	tokens := []token{}
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, token{text: expr[i : i+1], pos: i})
+			i++
+		case c == '=' || c == '!':
+			if i+1 >= len(expr) || expr[i+1] != '=' {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+			tokens = append(tokens, token{text: expr[i : i+2], pos: i})
+			i += 2
+		case c == '"':
+			end := i + 1
+			for end < len(expr) && expr[end] != '"' {
+				if expr[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			value, err := strconv.Unquote(expr[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("bad string at %d: %v", i, err)
+			}
+			tokens = append(tokens, token{text: value, quoted: true, pos: i})
+			i = end + 1
+		case c == '_' || unicode.IsLetter(rune(c)):
+			end := i
+			for end < len(expr) && (expr[end] == '_' || unicode.IsLetter(rune(expr[end]))) {
+				end++
+			}
+			tokens = append(tokens, token{text: expr[i:end], pos: i})
+			i = end
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", c, i)
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens     []token
+	pos        int
+	end        int
+	conditions []*condition
+	negated    bool
+}
+
+func (p *parser) peek(text string) bool {
+	return p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && p.tokens[p.pos].text == text
+}
+
+func (p *parser) next(what string) (token, error) {
+	if p.pos >= len(p.tokens) {
+		return token{}, fmt.Errorf("expected %s at %d, got end of query", what, p.end)
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+func (p *parser) or() (node, error) {
+	result := orNode{}
+	for {
+		child, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, child)
+		if !p.peek("or") {
+			break
+		}
+		p.pos++
+	}
+	if len(result) == 1 {
+		return result[0], nil
+	}
+	return result, nil
+}
+
+func (p *parser) and() (node, error) {
+	result := andNode{}
+	for {
+		child, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, child)
+		if !p.peek("and") {
+			break
+		}
+		p.pos++
+	}
+	if len(result) == 1 {
+		return result[0], nil
+	}
+	return result, nil
+}
+
+func (p *parser) unary() (node, error) {
+	if p.peek("not") {
+		p.pos++
+		child, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		p.negated = true
+		return notNode{child}, nil
+	}
+
+	if p.peek("(") {
+		p.pos++
+		child, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		closing, err := p.next(`")"`)
+		if err != nil {
+			return nil, err
+		}
+		if closing.quoted || closing.text != ")" {
+			return nil, fmt.Errorf(`expected ")" at %d, got %q`, closing.pos, closing.text)
+		}
+		return child, nil
+	}
+
+	return p.condition()
+}
+
+func (p *parser) condition() (node, error) {
+	name, err := p.next("field")
+	if err != nil {
+		return nil, err
+	}
+	f, ok := fields[name.text]
+	if !ok || name.quoted {
+		return nil, fmt.Errorf("unknown field %q at %d", name.text, name.pos)
+	}
+
+	op, err := p.next("operator")
+	if err != nil {
+		return nil, err
+	}
+	if op.quoted || (op.text != "contains" && op.text != "==" && op.text != "!=") {
+		return nil, fmt.Errorf("unknown operator %q at %d", op.text, op.pos)
+	}
+
+	value, err := p.next("string")
+	if err != nil {
+		return nil, err
+	}
+	if !value.quoted {
+		return nil, fmt.Errorf("expected string at %d, got %q", value.pos, value.text)
+	}
+
+	c := &condition{field: f, contains: op.text == "contains", value: value.text}
+	p.conditions = append(p.conditions, c)
+	if op.text == "!=" {
+		p.negated = true
+		return notNode{c}, nil
+	}
+	return c, nil
+}
+
+func ParseQuery(expr string) (*Query, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, fmt.Errorf("query: %v", err)
+	}
+
+	p := &parser{tokens: tokens, end: len(expr)}
+	root, err := p.or()
+	if err != nil {
+		return nil, fmt.Errorf("query: %v", err)
+	}
+	if p.pos < len(tokens) {
+		return nil, fmt.Errorf("query: unexpected %q at %d", tokens[p.pos].text, tokens[p.pos].pos)
+	}
+
+	return &Query{root: root, conditions: p.conditions, negated: p.negated}, nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Template formats a found user. {index}, {name}, {email} and {browsers}
+// are replaced with the line index and the user fields, {email|at} is the
+// email with @ replaced by " [at] ", {{ and }} are literal braces.
+type Template struct {
+	parts []templatePart
+}
+
+type templatePart struct {
+	text  string
+	field string
+}
+
+var templateFields = map[string]bool{"index": true, "name": true, "email": true, "email|at": true, "browsers": true}
+
+func ParseTemplate(text string) (*Template, error) {
+	t := &Template{}
+	literal := []byte{}
+	for i := 0; i < len(text); i++ {
+		switch {
+		case strings.HasPrefix(text[i:], "{{") || strings.HasPrefix(text[i:], "}}"):
+			literal = append(literal, text[i])
+			i++
+		case text[i] == '{':
+			end := strings.IndexByte(text[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("template: unclosed { at %d", i)
+			}
+			name := text[i+1 : i+end]
+			if !templateFields[name] {
+				return nil, fmt.Errorf("template: unknown field %q", name)
+			}
+			if len(literal) > 0 {
+				t.parts = append(t.parts, templatePart{text: string(literal)})
+				literal = literal[:0]
+			}
+			t.parts = append(t.parts, templatePart{field: name})
+			i += end
+		case text[i] == '}':
+			return nil, fmt.Errorf("template: unexpected } at %d", i)
+		default:
+			literal = append(literal, text[i])
+		}
+	}
+	if len(literal) > 0 {
+		t.parts = append(t.parts, templatePart{text: string(literal)})
+	}
+	return t, nil
+}
+
+// AppendTo appends the formatted user to buf.
+func (t *Template) AppendTo(buf []byte, index int, user *User) []byte {
+	for _, part := range t.parts {
+		switch part.field {
+		case "":
+			buf = append(buf, part.text...)
+		case "index":
+			buf = strconv.AppendInt(buf, int64(index), 10)
+		case "name":
+			buf = append(buf, user.Name...)
+		case "email":
+			buf = append(buf, user.Email...)
+		case "email|at":
+			email := user.Email
+			for {
+				at := strings.IndexByte(email, '@')
+				if at < 0 {
+					break
+				}
+				buf = append(buf, email[:at]...)
+				buf = append(buf, " [at] "...)
+				email = email[at+1:]
+			}
+			buf = append(buf, email...)
+		case "browsers":
+			for i, browser := range user.Browsers {
+				if i > 0 {
+					buf = append(buf, ", "...)
+				}
+				buf = append(buf, browser...)
+			}
+		}
+	}
+	return buf
+}
+
+// SearchStats are the counts of a search: Lines read, Matched users and
+// UniqueBrowsers, the distinct browsers a browsers condition of the query
+// holds for, whether the user matched or not.
+type SearchStats struct {
+	Lines          int
+	Matched        int
+	UniqueBrowsers int
+}
+
+// Searcher streams users, one JSON object per line, and writes the ones
+// matching the query formatted with the template, a line each. Lines that
+// contain none of the query strings are skipped without being decoded, so
+// the query strings are expected to appear unescaped in the input; the
+// check is off for queries with not or !=.
+type Searcher struct {
+	query     *Query
+	template  *Template
+	browsers  []*condition
+	filter    [][]byte
+	chunkSize int
+}
+
+func NewSearcher(query string, template string) (*Searcher, error) {
+	q, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	t, err := ParseTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Searcher{query: q, template: t, browsers: q.browserConditions(), chunkSize: defaultChunkSize}
+	if !q.negated {
+		for _, c := range q.conditions {
+			if c.value == "" {
+				s.filter = nil
+				break
+			}
+			s.filter = append(s.filter, []byte(c.value))
+		}
+	}
+	return s, nil
+}
+
+func (s *Searcher) skip(line []byte) bool {
+	if s.filter == nil {
+		return false
+	}
+	for _, value := range s.filter {
+		if bytes.Contains(line, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Search reads users from r until EOF.
+func (s *Searcher) Search(r io.Reader, out io.Writer) (SearchStats, error) {
+	stats := SearchStats{}
+	seen := map[string]struct{}{}
+	scanner := bufio.NewScanner(r)
+	user := &User{}
+	buf := []byte{}
+
+	for ; scanner.Scan(); stats.Lines++ {
+		matched, err := s.check(scanner.Bytes(), user, seen)
+		if err != nil {
+			return stats, fmt.Errorf("line %d: %v", stats.Lines+1, err)
+		}
+		if !matched {
+			continue
+		}
+
+		stats.Matched++
+		buf = s.template.AppendTo(buf[:0], stats.Lines, user)
+		buf = append(buf, '\n')
+		_, err = out.Write(buf)
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	stats.UniqueBrowsers = len(seen)
+	return stats, scanner.Err()
+}
+
+// check decodes line into user unless it is skipped and adds the browsers
+// a browsers condition holds for to seen.
+func (s *Searcher) check(line []byte, user *User, seen map[string]struct{}) (bool, error) {
+	if s.skip(line) {
+		return false, nil
+	}
+
+	user.Name, user.Email, user.Browsers = "", "", user.Browsers[:0]
+	err := user.UnmarshalJSON(line)
+	if err != nil {
+		return false, err
+	}
+
+	for _, browser := range user.Browsers {
+		for _, c := range s.browsers {
+			if c.test(browser) {
+				seen[browser] = struct{}{}
+				break
+			}
+		}
+	}
+
+	return s.query.Match(user), nil
+}
+
+// SearchFile searches the file at path, see OpenInput.
+func (s *Searcher) SearchFile(path string, out io.Writer) (SearchStats, error) {
+	f, err := OpenInput(path)
+	if err != nil {
+		return SearchStats{}, err
+	}
+	defer f.Close()
+	return s.Search(f, out)
+}
+
+func (s *Searcher) SearchFileParallel(path string, out io.Writer, workers int) (SearchStats, error) {
+	f, err := OpenInput(path)
+	if err != nil {
+		return SearchStats{}, err
+	}
+	defer f.Close()
+	return s.SearchParallel(f, out, workers)
+}
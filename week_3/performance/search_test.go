@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+const searchUsers = `{"browsers":["Mozilla/5.0 (Android; Linux armv7l)","Mozilla/4.0 (compatible; MSIE 8.0)"],"email":"first@mail.ru","name":"First"}
+{"browsers":["Opera/9.80 (Android 2.3.3)"],"email":"second@mail.ru","name":"Second"}
+{"browsers":["Mozilla/4.0 (compatible; MSIE 7.0)","Opera/9.80"],"email":"third@gmail.com","name":"Third"}
+{"email":"fourth@gmail.com","name":"Fourth"}`
+
+func search(t *testing.T, query string, template string) (string, SearchStats) {
+	t.Helper()
+	s, err := NewSearcher(query, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	stats, err := s.Search(strings.NewReader(searchUsers), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.String(), stats
+}
+
+func TestSearcher(t *testing.T) {
+	cases := []struct {
+		query    string
+		expected string
+		browsers int
+	}{
+		{`browsers contains "Android" and browsers contains "MSIE"`, "0 First\n", 4},
+		{`browsers contains "Android" or browsers contains "MSIE"`, "0 First\n1 Second\n2 Third\n", 4},
+		{`browsers contains "Opera" and not browsers contains "Android"`, "2 Third\n", 3},
+		{`browsers == "Opera/9.80"`, "2 Third\n", 1},
+		{`email contains "gmail" and (name == "Fourth" or name == "First")`, "3 Fourth\n", 0},
+		{`name != "First" and email contains "mail.ru"`, "1 Second\n", 0},
+		{`not (name == "First" or name == "Second")`, "2 Third\n3 Fourth\n", 0},
+	}
+
+	for _, c := range cases {
+		out, stats := search(t, c.query, "{index} {name}")
+		if out != c.expected || stats.UniqueBrowsers != c.browsers || stats.Lines != 4 {
+			t.Errorf("%s: got %q, %+v\nExpected: %q, %d browsers", c.query, out, stats, c.expected, c.browsers)
+		}
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	out, stats := search(t, `name == "First"`, "{{{index}}} {name} <{email|at}> {email}: {browsers}")
+	expected := "{0} First <first [at] mail.ru> first@mail.ru: Mozilla/5.0 (Android; Linux armv7l), Mozilla/4.0 (compatible; MSIE 8.0)\n"
+	if out != expected || stats.Matched != 1 {
+		t.Errorf("results not match\nGot: %q\nExpected: %q", out, expected)
+	}
+}
+
+func TestSearcherErrors(t *testing.T) {
+	cases := map[string][2]string{
+		`query: unknown field "phone" at 0`:             {`phone == "1"`, "{name}"},
+		`query: unknown operator "is" at 5`:             {`name is "1"`, "{name}"},
+		`query: unexpected '~' at 5`:                    {`name ~ "1"`, "{name}"},
+		`query: expected string at 8, got "and"`:        {`name == and`, "{name}"},
+		`query: expected string at 8, got end of query`: {`name == `, "{name}"},
+		`query: unterminated string at 8`:               {`name == "x`, "{name}"},
+		`query: expected ")" at 12, got end of query`:   {`(name == "x"`, "{name}"},
+		`query: unexpected ")" at 11`:                   {`name == "x")`, "{name}"},
+		`template: unknown field "phone"`:               {`name == "x"`, "{phone}"},
+		`template: unclosed { at 0`:                     {`name == "x"`, "{name"},
+		`template: unexpected } at 4`:                   {`name == "x"`, "name}"},
+	}
+	for expected, args := range cases {
+		_, err := NewSearcher(args[0], args[1])
+		if err == nil || err.Error() != expected {
+			t.Errorf("%v: expected %q, got %v", args, expected, err)
+		}
+	}
+
+	s, _ := NewSearcher(`name == "x"`, "{name}")
+	_, err := s.Search(strings.NewReader("{}\n{\"name\":\"x\"\n"), new(bytes.Buffer))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2: ") {
+		t.Errorf("bad json is not reported: %v", err)
+	}
+}
+
+func BenchmarkSearcher(b *testing.B) {
+	s, err := NewSearcher(fastQuery, fastTemplate)
+	if err != nil {
+		b.Fatal(err)
+	}
+	data := []byte(strings.Repeat(searchUsers+"\n", 100))
+	out := new(bytes.Buffer)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		out.Reset()
+		s.Search(bytes.NewReader(data), out)
+	}
+}
+
+func TestSearchParallelChunks(t *testing.T) {
+	s, err := NewSearcher(`browsers contains "Android" or browsers contains "MSIE"`, "{index} {name}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	long := `{"browsers":["MSIE ` + strings.Repeat("x", 300) + `"],"name":"Long"}`
+	inputs := []string{
+		searchUsers,
+		searchUsers + "\n",
+		strings.ReplaceAll(searchUsers, "\n", "\r\n"),
+		strings.Repeat(searchUsers+"\n", 20) + long + "\n" + searchUsers,
+		"",
+	}
+
+	for i, input := range inputs {
+		expectedOut := new(bytes.Buffer)
+		expected, err := s.Search(strings.NewReader(input), expectedOut)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, chunkSize := range []int{1, 50, 200, defaultChunkSize} {
+			for _, workers := range []int{1, 3} {
+				s.chunkSize = chunkSize
+				out := new(bytes.Buffer)
+				stats, err := s.SearchParallel(strings.NewReader(input), out, workers)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if out.String() != expectedOut.String() || stats != expected {
+					t.Errorf("input %d, chunk %d, %d workers: got %q, %+v\nExpected: %q, %+v",
+						i, chunkSize, workers, out.String(), stats, expectedOut.String(), expected)
+				}
+			}
+		}
+	}
+}
+
+func TestSearchParallelError(t *testing.T) {
+	before := runtime.NumGoroutine()
+	s, _ := NewSearcher(`name contains "x"`, "{index}")
+	s.chunkSize = 20
+	input := strings.Repeat(`{"name":"x"}`+"\n", 10) + "{\"name\":\"x\"\n" + strings.Repeat(`{"name":"x"}`+"\n", 100)
+
+	out := new(bytes.Buffer)
+	stats, err := s.SearchParallel(strings.NewReader(input), out, 4)
+	if err == nil || !strings.HasPrefix(err.Error(), "line 11: ") {
+		t.Errorf("bad json is not reported: %v", err)
+	}
+	if stats.Matched != 10 || strings.Count(out.String(), "\n") != 10 {
+		t.Errorf("lines before the error are not written: %+v, %q", stats, out.String())
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if runtime.NumGoroutine() > before {
+		t.Errorf("goroutines leaked: %d before, %d after", before, runtime.NumGoroutine())
+	}
+}